@@ -18,48 +18,166 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/": {
-            "get": {
-                "description": "Obtiene información general sobre la API",
+        "/compress": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Comprime una imagen individual con la calidad y formato especificados",
                 "consumes": [
-                    "application/json"
+                    "multipart/form-data"
                 ],
                 "produces": [
+                    "application/octet-stream",
                     "application/json"
                 ],
                 "tags": [
-                    "General"
+                    "Compression"
+                ],
+                "summary": "Comprimir una imagen",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Archivo de imagen a comprimir",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Preset configurado en PRESETS_FILE: aporta calidad y formato por defecto y el Cache-Control del resultado",
+                        "name": "preset",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 80,
+                        "description": "Calidad de compresión (1-100)",
+                        "name": "quality",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "jpeg",
+                            "png",
+                            "webp"
+                        ],
+                        "type": "string",
+                        "default": "jpeg",
+                        "description": "Formato de salida (jpeg, png, webp)",
+                        "name": "format",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "keep",
+                            "original",
+                            "error"
+                        ],
+                        "type": "string",
+                        "default": "original",
+                        "description": "Qué hacer si el resultado no es más pequeño (keep, original, error)",
+                        "name": "if_larger",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Guardar la imagen en el almacenamiento y responder con su clave en JSON",
+                        "name": "upload",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag de un resultado anterior; si coincide se responde 304 sin comprimir",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
                 ],
-                "summary": "Información de la API",
                 "responses": {
                     "200": {
-                        "description": "Información de la API",
+                        "description": "Imagen guardada en el almacenamiento (upload=true)",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/domain.UploadedImage"
+                        }
+                    },
+                    "304": {
+                        "description": "El resultado no cambió respecto al ETag de If-None-Match",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Error en la solicitud",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Formato de salida no permitido para la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "La imagen supera el tamaño máximo (MAX_IMAGE_SIZE o max_image_size de la API key)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "El resultado no es más pequeño que la original (if_larger=error)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error interno del servidor",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/compress": {
+        "/compress/archive": {
             "post": {
-                "description": "Comprime una imagen individual con la calidad y formato especificados",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Recibe un ZIP, comprime cada imagen conservando la estructura de carpetas, copia sin cambios el resto de archivos y devuelve un nuevo ZIP (o TAR/TAR.GZ). Las carpetas vacías y los enlaces simbólicos no se conservan.\nLos campos quality, format e if_larger deben enviarse antes que el archivo.",
                 "consumes": [
                     "multipart/form-data"
                 ],
                 "produces": [
-                    "application/octet-stream"
+                    "application/zip",
+                    "application/x-tar",
+                    "application/gzip"
                 ],
                 "tags": [
                     "Compression"
                 ],
-                "summary": "Comprimir una imagen",
+                "summary": "Comprimir un archivo ZIP",
                 "parameters": [
                     {
                         "type": "file",
-                        "description": "Archivo de imagen a comprimir",
-                        "name": "image",
+                        "description": "Archivo ZIP con imágenes",
+                        "name": "archive",
                         "in": "formData",
                         "required": true
                     },
@@ -81,17 +199,100 @@ const docTemplate = `{
                         "description": "Formato de salida (jpeg, png, webp)",
                         "name": "format",
                         "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "keep",
+                            "original",
+                            "error"
+                        ],
+                        "type": "string",
+                        "default": "original",
+                        "description": "Qué hacer si el resultado no es más pequeño (keep, original, error)",
+                        "name": "if_larger",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar",
+                            "tar.gz"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "Formato del archivo de salida (zip, tar, tar.gz)",
+                        "name": "archive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen",
+                        "name": "stream",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Incluir manifest.csv además de manifest.json",
+                        "name": "manifest_csv",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fail",
+                            "skip"
+                        ],
+                        "type": "string",
+                        "default": "fail",
+                        "description": "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa",
+                        "name": "on_error",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Imagen comprimida",
+                        "description": "Archivo con imágenes comprimidas",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "207": {
+                        "description": "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json",
                         "schema": {
                             "type": "file"
                         }
                     },
                     "400": {
-                        "description": "Error en la solicitud",
+                        "description": "Error en la solicitud, ZIP inválido, ruta insegura o más imágenes que el max_batch_size de la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Formato de salida no permitido para la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "El ZIP excede los límites de tamaño o descompresión",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Alguna imagen no es más pequeña que la original (if_larger=error)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
                         "schema": {
                             "type": "string"
                         }
@@ -107,12 +308,20 @@ const docTemplate = `{
         },
         "/compress/batch": {
             "post": {
-                "description": "Comprime múltiples imágenes y las devuelve en un archivo ZIP",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Comprime múltiples imágenes y las devuelve en un archivo ZIP, TAR o TAR.GZ.\nEl formato se elige con el campo/parámetro archive o, si no se indica, con el header Accept.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/zip"
+                    "application/zip",
+                    "application/x-tar",
+                    "application/gzip",
+                    "application/json"
                 ],
                 "tags": [
                     "Compression"
@@ -127,11 +336,58 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/domain.BatchCompressionRequest"
                         }
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar",
+                            "tar.gz"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "Formato del archivo de salida (zip, tar, tar.gz)",
+                        "name": "archive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen",
+                        "name": "stream",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Incluir manifest.csv además de manifest.json",
+                        "name": "manifest_csv",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fail",
+                            "skip"
+                        ],
+                        "type": "string",
+                        "default": "fail",
+                        "description": "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa",
+                        "name": "on_error",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Guardar cada imagen en el almacenamiento y responder con sus claves en JSON en lugar del archivo",
+                        "name": "upload",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Archivo ZIP con imágenes comprimidas",
+                        "description": "Imágenes guardadas en el almacenamiento (upload=true)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UploadResult"
+                        }
+                    },
+                    "207": {
+                        "description": "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json",
                         "schema": {
                             "type": "file"
                         }
@@ -142,6 +398,30 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Formato de salida no permitido para la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Alguna imagen no es más pequeña que la original (if_larger=error)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Error interno del servidor",
                         "schema": {
@@ -151,34 +431,112 @@ const docTemplate = `{
                 }
             }
         },
-        "/compress/info": {
+        "/compress/batch/multipart": {
             "post": {
-                "description": "Obtiene información detallada de una imagen",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lee cada parte images[] como stream, la comprime y la agrega al archivo de salida sin codificar en base64.\nLos campos quality, format e if_larger deben enviarse antes que las imágenes.",
                 "consumes": [
                     "multipart/form-data"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/zip",
+                    "application/x-tar",
+                    "application/gzip"
                 ],
                 "tags": [
                     "Compression"
                 ],
-                "summary": "Obtener información de imagen",
+                "summary": "Comprimir múltiples imágenes (multipart)",
                 "parameters": [
                     {
                         "type": "file",
-                        "description": "Archivo de imagen a analizar",
-                        "name": "image",
+                        "description": "Archivos de imagen a comprimir (repetir el campo por cada imagen)",
+                        "name": "images[]",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 80,
+                        "description": "Calidad de compresión (1-100)",
+                        "name": "quality",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "jpeg",
+                            "png",
+                            "webp"
+                        ],
+                        "type": "string",
+                        "default": "jpeg",
+                        "description": "Formato de salida (jpeg, png, webp)",
+                        "name": "format",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "keep",
+                            "original",
+                            "error"
+                        ],
+                        "type": "string",
+                        "default": "original",
+                        "description": "Qué hacer si el resultado no es más pequeño (keep, original, error)",
+                        "name": "if_larger",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar",
+                            "tar.gz"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "Formato del archivo de salida (zip, tar, tar.gz)",
+                        "name": "archive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen",
+                        "name": "stream",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Incluir manifest.csv además de manifest.json",
+                        "name": "manifest_csv",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fail",
+                            "skip"
+                        ],
+                        "type": "string",
+                        "default": "fail",
+                        "description": "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa",
+                        "name": "on_error",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Información de la imagen",
+                        "description": "Archivo con imágenes comprimidas",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "type": "file"
+                        }
+                    },
+                    "207": {
+                        "description": "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json",
+                        "schema": {
+                            "type": "file"
                         }
                     },
                     "400": {
@@ -187,23 +545,115 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Error interno del servidor",
+                    "401": {
+                        "description": "API key inválida o ausente",
                         "schema": {
                             "type": "string"
                         }
-                    }
-                }
-            }
-        },
-        "/health": {
-            "get": {
-                "description": "Verifica el estado de la API",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
+                    },
+                    "403": {
+                        "description": "Formato de salida no permitido para la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Alguna imagen no es más pequeña que la original (if_larger=error)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error interno del servidor",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/compress/info": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Obtiene información detallada de una imagen",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Compression"
+                ],
+                "summary": "Obtener información de imagen",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Archivo de imagen a analizar",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Información de la imagen",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Error en la solicitud",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "La imagen supera el tamaño máximo (MAX_IMAGE_SIZE o max_image_size de la API key)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error interno del servidor",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Verifica el estado de la API",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
                 ],
                 "tags": [
                     "General"
@@ -219,18 +669,348 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/jobs": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Acepta el mismo cuerpo que /compress/batch, responde de inmediato con el ID del trabajo y procesa el lote en segundo plano, sin el límite de REQUEST_TIMEOUT.\nSi se indica callback_url, al terminar se envía a esa URL un POST JSON firmado (header X-Webhook-Signature) con el estado, las estadísticas por imagen y el enlace de descarga. La URL debe resolver a direcciones públicas y requiere WEBHOOK_SECRET; si no, se responde 400.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Jobs"
+                ],
+                "summary": "Crear un trabajo de compresión en lote",
+                "parameters": [
+                    {
+                        "description": "Datos de compresión en lote, callback_url y preset opcionales",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.JobRequest"
+                        }
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar",
+                            "tar.gz"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "Formato del archivo de salida (zip, tar, tar.gz)",
+                        "name": "archive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Incluir manifest.csv además de manifest.json",
+                        "name": "manifest_csv",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fail",
+                            "skip"
+                        ],
+                        "type": "string",
+                        "default": "fail",
+                        "description": "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa",
+                        "name": "on_error",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Trabajo creado (header Location: URL de estado)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.Job"
+                        }
+                    },
+                    "400": {
+                        "description": "Error en la solicitud",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Formato de salida no permitido para la API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "El cuerpo supera MAX_JOB_BODY_SIZE",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Ya hay MAX_CONCURRENT_JOBS trabajos en curso (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Devuelve el estado (queued, running, completed, failed) y el progreso de un trabajo",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Jobs"
+                ],
+                "summary": "Estado de un trabajo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID del trabajo",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Estado del trabajo",
+                        "schema": {
+                            "$ref": "#/definitions/domain.Job"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Trabajo no encontrado o creado con otra API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream text/event-stream con un evento por imagen (image.started, image.completed, image.failed) con el ahorro en bytes, y un evento final job.completed o job.failed.\nLos eventos ya ocurridos se envían al conectarse; con el header Last-Event-ID se reanuda tras el último recibido.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Jobs"
+                ],
+                "summary": "Progreso de un trabajo (SSE)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID del trabajo",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Stream de eventos",
+                        "schema": {
+                            "$ref": "#/definitions/domain.JobEvent"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Trabajo no encontrado o creado con otra API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}/result": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Descarga el archivo (ZIP, TAR o TAR.GZ) generado por un trabajo terminado. El header Cache-Control es el del preset del trabajo, con max-age limitado al tiempo que le queda al trabajo antes de eliminarse y private si la petición usa una API key.",
+                "produces": [
+                    "application/zip",
+                    "application/x-tar",
+                    "application/gzip"
+                ],
+                "tags": [
+                    "Jobs"
+                ],
+                "summary": "Resultado de un trabajo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID del trabajo",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag de una descarga anterior; si coincide se responde 304",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Archivo con imágenes comprimidas",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "207": {
+                        "description": "Archivo con las imágenes que se pudieron comprimir (on_error=skip)",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "El archivo no cambió respecto al ETag de If-None-Match",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Trabajo no encontrado o creado con otra API key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El trabajo aún no ha terminado o terminó con error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Devuelve los contadores acumulados desde que arrancó el servidor: imágenes procesadas (incluidas las servidas desde la caché), bytes ahorrados, relación media por formato de salida y los errores más frecuentes. Con API keys, cada clave recibe solo sus propios contadores; el total y el desglose por clave (keys) requieren una clave con admin.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "General"
+                ],
+                "summary": "Estadísticas de uso",
+                "responses": {
+                    "200": {
+                        "description": "Estadísticas de uso",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UsageStats"
+                        }
+                    },
+                    "401": {
+                        "description": "API key inválida o ausente",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Límite de peticiones o cuota diaria agotados (ver Retry-After)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "domain.ArchiveFormat": {
+            "type": "string",
+            "enum": [
+                "zip",
+                "tar",
+                "tar.gz"
+            ],
+            "x-enum-varnames": [
+                "ArchiveZIP",
+                "ArchiveTAR",
+                "ArchiveTARGZ"
+            ]
+        },
         "domain.BatchCompressionRequest": {
             "type": "object",
             "required": [
                 "images"
             ],
             "properties": {
+                "archive": {
+                    "$ref": "#/definitions/domain.ArchiveFormat"
+                },
                 "format": {
                     "$ref": "#/definitions/domain.ImageFormat"
                 },
+                "if_larger": {
+                    "$ref": "#/definitions/domain.IfLargerPolicy"
+                },
                 "images": {
                     "type": "array",
                     "maxItems": 10,
@@ -239,6 +1019,13 @@ const docTemplate = `{
                         "$ref": "#/definitions/domain.ImageData"
                     }
                 },
+                "manifest_csv": {
+                    "description": "ManifestCSV incluye manifest.csv además de manifest.json",
+                    "type": "boolean"
+                },
+                "on_error": {
+                    "$ref": "#/definitions/domain.ErrorPolicy"
+                },
                 "quality": {
                     "type": "integer",
                     "maximum": 100,
@@ -246,6 +1033,70 @@ const docTemplate = `{
                 }
             }
         },
+        "domain.ErrorCount": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.ErrorPolicy": {
+            "type": "string",
+            "enum": [
+                "fail",
+                "skip"
+            ],
+            "x-enum-comments": {
+                "OnErrorFail": "Abortar el lote completo",
+                "OnErrorSkip": "Registrar el error en el manifiesto y continuar"
+            },
+            "x-enum-varnames": [
+                "OnErrorFail",
+                "OnErrorSkip"
+            ]
+        },
+        "domain.FormatStats": {
+            "type": "object",
+            "properties": {
+                "average_ratio": {
+                    "type": "number"
+                },
+                "bytes_saved": {
+                    "type": "integer"
+                },
+                "images": {
+                    "type": "integer"
+                },
+                "input_bytes": {
+                    "type": "integer"
+                },
+                "output_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.IfLargerPolicy": {
+            "type": "string",
+            "enum": [
+                "keep",
+                "original",
+                "error"
+            ],
+            "x-enum-comments": {
+                "IfLargerError": "Devolver ErrOutputNotSmaller",
+                "IfLargerKeep": "Devolver siempre la imagen recomprimida",
+                "IfLargerOriginal": "Devolver los bytes originales sin modificar (por defecto)"
+            },
+            "x-enum-varnames": [
+                "IfLargerKeep",
+                "IfLargerOriginal",
+                "IfLargerError"
+            ]
+        },
         "domain.ImageData": {
             "type": "object",
             "required": [
@@ -276,6 +1127,361 @@ const docTemplate = `{
                 "PNG",
                 "WEBP"
             ]
+        },
+        "domain.Job": {
+            "type": "object",
+            "properties": {
+                "callback_url": {
+                    "description": "Recibe una notificación webhook al terminar",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "preset": {
+                    "description": "Define el Cache-Control del resultado",
+                    "type": "string"
+                },
+                "processed": {
+                    "description": "Imágenes procesadas (correctas o fallidas)",
+                    "type": "integer"
+                },
+                "progress": {
+                    "description": "Porcentaje procesado (0-100)",
+                    "type": "integer"
+                },
+                "result_url": {
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/domain.JobStatus"
+                },
+                "succeeded": {
+                    "type": "integer"
+                },
+                "total": {
+                    "description": "Imágenes del lote",
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.JobEvent": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "error_code": {
+                    "type": "string"
+                },
+                "index": {
+                    "description": "Posición de la imagen en el lote, empezando en 1",
+                    "type": "integer"
+                },
+                "input": {
+                    "type": "string"
+                },
+                "input_size": {
+                    "type": "integer"
+                },
+                "job": {
+                    "description": "Estado final, solo en job.completed y job.failed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.Job"
+                        }
+                    ]
+                },
+                "output": {
+                    "type": "string"
+                },
+                "output_size": {
+                    "type": "integer"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "ratio": {
+                    "type": "number"
+                },
+                "saved_bytes": {
+                    "description": "input_size - output_size",
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.JobRequest": {
+            "type": "object",
+            "required": [
+                "images"
+            ],
+            "properties": {
+                "archive": {
+                    "$ref": "#/definitions/domain.ArchiveFormat"
+                },
+                "callback_url": {
+                    "type": "string"
+                },
+                "format": {
+                    "$ref": "#/definitions/domain.ImageFormat"
+                },
+                "if_larger": {
+                    "$ref": "#/definitions/domain.IfLargerPolicy"
+                },
+                "images": {
+                    "type": "array",
+                    "maxItems": 10,
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/domain.ImageData"
+                    }
+                },
+                "manifest_csv": {
+                    "description": "ManifestCSV incluye manifest.csv además de manifest.json",
+                    "type": "boolean"
+                },
+                "on_error": {
+                    "$ref": "#/definitions/domain.ErrorPolicy"
+                },
+                "preset": {
+                    "type": "string"
+                },
+                "quality": {
+                    "type": "integer",
+                    "maximum": 100,
+                    "minimum": 1
+                }
+            }
+        },
+        "domain.JobStatus": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "running",
+                "completed",
+                "failed"
+            ],
+            "x-enum-comments": {
+                "JobCompleted": "Terminado, el resultado se puede descargar",
+                "JobFailed": "Terminado con error, no hay resultado",
+                "JobQueued": "Creado, aún no comenzó",
+                "JobRunning": "Procesando imágenes"
+            },
+            "x-enum-varnames": [
+                "JobQueued",
+                "JobRunning",
+                "JobCompleted",
+                "JobFailed"
+            ]
+        },
+        "domain.UploadResult": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer"
+                },
+                "images": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.UploadedImage"
+                    }
+                },
+                "succeeded": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.UploadedImage": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Mensaje si la imagen falló (on_error=skip)",
+                    "type": "string"
+                },
+                "error_code": {
+                    "description": "Código estable del error, ver ErrorCode",
+                    "type": "string"
+                },
+                "etag": {
+                    "type": "string"
+                },
+                "format": {
+                    "$ref": "#/definitions/domain.ImageFormat"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "input": {
+                    "type": "string"
+                },
+                "input_size": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "original": {
+                    "type": "boolean"
+                },
+                "output": {
+                    "type": "string"
+                },
+                "output_size": {
+                    "type": "integer"
+                },
+                "quality": {
+                    "type": "integer"
+                },
+                "ratio": {
+                    "description": "output_size / input_size",
+                    "type": "number"
+                },
+                "requested_format": {
+                    "description": "Formato pedido si no coincide con format, ver CompressionResult.RequestedFormat",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.ImageFormat"
+                        }
+                    ]
+                },
+                "url": {
+                    "description": "Vacía si no se configuró UPLOAD_PUBLIC_URL",
+                    "type": "string"
+                },
+                "warnings": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.UsageStats": {
+            "type": "object",
+            "properties": {
+                "average_ratio": {
+                    "description": "Media de tamaño de salida / tamaño de entrada por imagen",
+                    "type": "number"
+                },
+                "bytes_saved": {
+                    "description": "Negativo si los resultados ocuparon más que las entradas",
+                    "type": "integer"
+                },
+                "formats": {
+                    "description": "Por formato de salida",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/domain.FormatStats"
+                    }
+                },
+                "images_failed": {
+                    "type": "integer"
+                },
+                "images_processed": {
+                    "description": "Incluye los resultados servidos desde la caché",
+                    "type": "integer"
+                },
+                "input_bytes": {
+                    "type": "integer"
+                },
+                "key": {
+                    "description": "API key a la que se limitan los contadores",
+                    "type": "string"
+                },
+                "keys": {
+                    "description": "Por nombre de API key, solo para claves admin",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/domain.UsageSummary"
+                    }
+                },
+                "output_bytes": {
+                    "type": "integer"
+                },
+                "since": {
+                    "type": "string"
+                },
+                "top_errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ErrorCount"
+                    }
+                },
+                "uptime_seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.UsageSummary": {
+            "type": "object",
+            "properties": {
+                "average_ratio": {
+                    "description": "Media de tamaño de salida / tamaño de entrada por imagen",
+                    "type": "number"
+                },
+                "bytes_saved": {
+                    "description": "Negativo si los resultados ocuparon más que las entradas",
+                    "type": "integer"
+                },
+                "formats": {
+                    "description": "Por formato de salida",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/domain.FormatStats"
+                    }
+                },
+                "images_failed": {
+                    "type": "integer"
+                },
+                "images_processed": {
+                    "description": "Incluye los resultados servidos desde la caché",
+                    "type": "integer"
+                },
+                "input_bytes": {
+                    "type": "integer"
+                },
+                "output_bytes": {
+                    "type": "integer"
+                },
+                "top_errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ErrorCount"
+                    }
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "API key, también se acepta como \"Authorization: Bearer \u003ckey\u003e\". Solo se exige si el servidor tiene claves configuradas.",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
         }
     }
 }`
@@ -6,6 +6,7 @@ package main
 import (
 	"log"
 
+	"github.com/swaggo/swag"
 	"github.com/swaggo/swag/gen"
 )
 
@@ -17,7 +18,7 @@ func main() {
 		MainAPIFile:        "image_compress.go",
 		PropNamingStrategy: "snakecase",
 		OutputTypes:        []string{"go", "json", "yaml"},
-		ParseDependency:    true,
+		ParseDependency:    swag.ParseModels,
 		ParseInternal:      false,
 		MarkdownFilesDir:   "",
 		GeneratedTime:      false,
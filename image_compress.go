@@ -6,16 +6,33 @@
 // @host localhost:8080
 // @BasePath /
 // @schemes http
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key, también se acepta como "Authorization: Bearer <key>". Solo se exige si el servidor tiene claves configuradas.
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -23,50 +40,321 @@ import (
 	"github.com/miguelmoralesr13/image-compress/internal/domain"
 	"github.com/miguelmoralesr13/image-compress/internal/services"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
+	// Logging estructurado en JSON; el nivel se configura antes que todo lo demás
+	logLevel := getEnv("LOG_LEVEL", "info")
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "LOG_LEVEL inválido: %q (use debug, info, warn o error)\n", logLevel)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(requestContextHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})}))
+
 	// Configuración desde variables de entorno
 	port := getEnv("PORT", "8080")
 	maxImageSizeStr := getEnv("MAX_IMAGE_SIZE", "33554432") // 32MB por defecto
 	maxBatchSizeStr := getEnv("MAX_BATCH_SIZE", "10")
 	requestTimeoutStr := getEnv("REQUEST_TIMEOUT", "60")
+	maxArchiveSizeStr := getEnv("MAX_ARCHIVE_SIZE", "104857600") // 100MB por defecto
+	maxArchiveEntriesStr := getEnv("MAX_ARCHIVE_ENTRIES", "1000")
+	maxArchiveUncompressedStr := getEnv("MAX_ARCHIVE_UNCOMPRESSED", "536870912") // 512MB por defecto
+	maxJobSizeStr := getEnv("MAX_JOB_SIZE", "100")
+	maxJobBodySizeStr := getEnv("MAX_JOB_BODY_SIZE", "268435456") // 256MB por defecto
+	maxConcurrentJobsStr := getEnv("MAX_CONCURRENT_JOBS", "10")
+	jobTimeoutStr := getEnv("JOB_TIMEOUT", "600") // 10 minutos por defecto
+	jobTTLStr := getEnv("JOB_TTL", "3600")        // 1 hora por defecto
+	publicURL := getEnv("PUBLIC_URL", "http://localhost:"+port)
+	storageBackend := getEnv("STORAGE_BACKEND", "memory")
+	uploadPrefix := getEnv("UPLOAD_PREFIX", "compressed")
+	uploadPublicURL := getEnv("UPLOAD_PUBLIC_URL", "")
+	webhookSecret := getEnv("WEBHOOK_SECRET", "")
+	webhookMaxRetriesStr := getEnv("WEBHOOK_MAX_RETRIES", "5")
+	webhookRetryDelayStr := getEnv("WEBHOOK_RETRY_DELAY", "1") // segundos antes del primer reintento
+	webhookTimeoutStr := getEnv("WEBHOOK_TIMEOUT", "300")      // segundos por notificación, con reintentos
+	webhookAllowPrivate := getEnv("WEBHOOK_ALLOW_PRIVATE", "false") == "true"
+	batchWorkersStr := getEnv("BATCH_WORKERS", strconv.Itoa(runtime.NumCPU()))
+	maxConcurrentStr := getEnv("MAX_CONCURRENT_COMPRESSIONS", strconv.Itoa(runtime.NumCPU()))
+	zipMethod := domain.ZipMethod(getEnv("ZIP_COMPRESSION", string(domain.ZipMethodAuto)))
+	cacheControl := getEnv("CACHE_CONTROL", "public, max-age=86400")
+	presetsFile := getEnv("PRESETS_FILE", "")                  // JSON con opciones y Cache-Control por preset
+	cacheMaxBytesStr := getEnv("CACHE_MAX_BYTES", "268435456") // 256MB por defecto, 0 desactiva la caché
+	cacheDir := getEnv("CACHE_DIR", "")
+	cacheDiskMaxBytesStr := getEnv("CACHE_DISK_MAX_BYTES", "1073741824") // 1GB por defecto
+	apiKeysList := getEnv("API_KEYS", "")                                // "nombre:clave" separados por comas
+	apiKeysFile := getEnv("API_KEYS_FILE", "")                           // JSON con límites por clave
+	metricsToken := getEnv("METRICS_TOKEN", "")                          // token del scraper de /metrics; vacío usa las API keys
+	rateLimitStr := getEnv("RATE_LIMIT", "0")                            // peticiones por segundo por cliente, 0 desactiva
+	rateLimitBurstStr := getEnv("RATE_LIMIT_BURST", "20")
+	dailyImageQuotaStr := getEnv("DAILY_IMAGE_QUOTA", "0") // imágenes por cliente y día, 0 sin límite
+	dailyByteQuotaStr := getEnv("DAILY_BYTE_QUOTA", "0")   // bytes de entrada por cliente y día, 0 sin límite
+	otlpEndpoint := getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	otlpTracesEndpoint := getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
 
 	// Convertir valores numéricos
 	maxImageSize, err := strconv.ParseInt(maxImageSizeStr, 10, 64)
 	if err != nil {
-		log.Fatalf("Error parseando MAX_IMAGE_SIZE: %v", err)
+		fatal("Error parseando MAX_IMAGE_SIZE", "error", err)
 	}
 
 	maxBatchSize, err := strconv.Atoi(maxBatchSizeStr)
 	if err != nil {
-		log.Fatalf("Error parseando MAX_BATCH_SIZE: %v", err)
+		fatal("Error parseando MAX_BATCH_SIZE", "error", err)
 	}
 
 	requestTimeout, err := strconv.Atoi(requestTimeoutStr)
 	if err != nil {
-		log.Fatalf("Error parseando REQUEST_TIMEOUT: %v", err)
+		fatal("Error parseando REQUEST_TIMEOUT", "error", err)
+	}
+
+	maxArchiveSize, err := strconv.ParseInt(maxArchiveSizeStr, 10, 64)
+	if err != nil {
+		fatal("Error parseando MAX_ARCHIVE_SIZE", "error", err)
+	}
+
+	maxArchiveEntries, err := strconv.Atoi(maxArchiveEntriesStr)
+	if err != nil {
+		fatal("Error parseando MAX_ARCHIVE_ENTRIES", "error", err)
+	}
+
+	maxArchiveUncompressed, err := strconv.ParseInt(maxArchiveUncompressedStr, 10, 64)
+	if err != nil {
+		fatal("Error parseando MAX_ARCHIVE_UNCOMPRESSED", "error", err)
+	}
+
+	maxJobSize, err := strconv.Atoi(maxJobSizeStr)
+	if err != nil {
+		fatal("Error parseando MAX_JOB_SIZE", "error", err)
+	}
+
+	maxJobBodySize, err := strconv.ParseInt(maxJobBodySizeStr, 10, 64)
+	if err != nil {
+		fatal("Error parseando MAX_JOB_BODY_SIZE", "error", err)
+	}
+
+	maxConcurrentJobs, err := strconv.Atoi(maxConcurrentJobsStr)
+	if err != nil || maxConcurrentJobs < 0 {
+		fatal("MAX_CONCURRENT_JOBS inválido, debe ser un entero no negativo", "value", maxConcurrentJobsStr)
+	}
+
+	jobTimeout, err := strconv.Atoi(jobTimeoutStr)
+	if err != nil {
+		fatal("Error parseando JOB_TIMEOUT", "error", err)
+	}
+
+	jobTTL, err := strconv.Atoi(jobTTLStr)
+	if err != nil {
+		fatal("Error parseando JOB_TTL", "error", err)
+	}
+
+	webhookMaxRetries, err := strconv.Atoi(webhookMaxRetriesStr)
+	if err != nil {
+		fatal("Error parseando WEBHOOK_MAX_RETRIES", "error", err)
+	}
+
+	webhookRetryDelay, err := strconv.Atoi(webhookRetryDelayStr)
+	if err != nil {
+		fatal("Error parseando WEBHOOK_RETRY_DELAY", "error", err)
+	}
+
+	webhookTimeout, err := strconv.Atoi(webhookTimeoutStr)
+	if err != nil {
+		fatal("Error parseando WEBHOOK_TIMEOUT", "error", err)
+	}
+	if webhookSecret == "" {
+		slog.Warn("WEBHOOK_SECRET no definido: los trabajos con callback_url se rechazarán")
+	}
+	if webhookAllowPrivate {
+		slog.Warn("WEBHOOK_ALLOW_PRIVATE activado: las notificaciones pueden enviarse a la red local")
+	}
+
+	batchWorkers, err := strconv.Atoi(batchWorkersStr)
+	if err != nil {
+		fatal("Error parseando BATCH_WORKERS", "error", err)
+	}
+
+	maxConcurrent, err := strconv.Atoi(maxConcurrentStr)
+	if err != nil {
+		fatal("Error parseando MAX_CONCURRENT_COMPRESSIONS", "error", err)
+	}
+
+	cacheMaxBytes, err := strconv.ParseInt(cacheMaxBytesStr, 10, 64)
+	if err != nil {
+		fatal("Error parseando CACHE_MAX_BYTES", "error", err)
+	}
+
+	cacheDiskMaxBytes, err := strconv.ParseInt(cacheDiskMaxBytesStr, 10, 64)
+	if err != nil {
+		fatal("Error parseando CACHE_DISK_MAX_BYTES", "error", err)
+	}
+
+	rateLimit, err := strconv.ParseFloat(rateLimitStr, 64)
+	if err != nil || rateLimit < 0 {
+		fatal("RATE_LIMIT inválido, debe ser un número no negativo", "value", rateLimitStr)
+	}
+
+	rateLimitBurst, err := strconv.Atoi(rateLimitBurstStr)
+	if err != nil || rateLimitBurst < 1 {
+		fatal("RATE_LIMIT_BURST inválido, debe ser un entero positivo", "value", rateLimitBurstStr)
+	}
+
+	dailyImageQuota, err := strconv.ParseInt(dailyImageQuotaStr, 10, 64)
+	if err != nil || dailyImageQuota < 0 {
+		fatal("DAILY_IMAGE_QUOTA inválido, debe ser un entero no negativo", "value", dailyImageQuotaStr)
+	}
+
+	dailyByteQuota, err := strconv.ParseInt(dailyByteQuotaStr, 10, 64)
+	if err != nil || dailyByteQuota < 0 {
+		fatal("DAILY_BYTE_QUOTA inválido, debe ser un entero no negativo", "value", dailyByteQuotaStr)
+	}
+
+	switch zipMethod {
+	case domain.ZipMethodAuto, domain.ZipMethodStore, domain.ZipMethodDeflate:
+	default:
+		fatal("ZIP_COMPRESSION inválido (use auto, store o deflate)", "value", zipMethod)
+	}
+
+	archiveLimits := domain.ZipLimits{
+		MaxEntries:   maxArchiveEntries,
+		MaxEntrySize: maxImageSize,
+		MaxTotalSize: maxArchiveUncompressed,
+		MaxRatio:     100,
+	}
+
+	// Autenticación con API key; sin claves configuradas la API queda abierta
+	keys, err := services.LoadAPIKeys(apiKeysFile, apiKeysList)
+	if err != nil {
+		fatal("Error cargando las API keys", "error", err)
+	}
+	var apiKeys *services.APIKeyStore
+	if len(keys) > 0 {
+		apiKeys, err = services.NewAPIKeyStore(keys)
+		if err != nil {
+			fatal("Error cargando las API keys", "error", err)
+		}
+	} else {
+		slog.Warn("API_KEYS y API_KEYS_FILE no definidos: la API no requiere autenticación")
+	}
+	if metricsToken == "" && apiKeys == nil {
+		slog.Warn("METRICS_TOKEN no definido y sin API keys: /metrics es público")
+	}
+
+	// Presets: opciones de compresión con nombre y su política de caché
+	presetList, err := services.LoadPresets(presetsFile)
+	if err != nil {
+		fatal("Error cargando los presets", "error", err)
+	}
+	presets, err := services.NewPresetStore(presetList, cacheControl)
+	if err != nil {
+		fatal("Error cargando los presets", "error", err)
+	}
+
+	// Trazas OpenTelemetry: se exportan por OTLP/HTTP solo si hay un endpoint configurado;
+	// el exportador lee el resto de variables OTEL_EXPORTER_OTLP_* por su cuenta
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var tracerProvider *sdktrace.TracerProvider
+	if otlpEndpoint != "" || otlpTracesEndpoint != "" {
+		tracerProvider, err = services.NewOTLPTracerProvider(context.Background(), "image-compress-api")
+		if err != nil {
+			fatal("Error inicializando las trazas", "error", err)
+		}
+		otel.SetTracerProvider(tracerProvider)
 	}
 
 	// Inicializar servicios (Inyección de dependencias)
-	imageProcessor := services.NewImageProcessorService(maxImageSize)
-	zipService := services.NewZipService()
+	storage, err := newStorage(storageBackend)
+	if err != nil {
+		fatal("Error inicializando el almacenamiento", "backend", storageBackend, "error", err)
+	}
+	metrics := services.NewMetrics()
+	usageStats := services.NewUsageStats()
+	quotas := services.NewQuotaTracker()
+	recorder := services.StatsRecorders{usageStats, quotas, metrics}
+	rateLimiter := services.NewRateLimiter()
+	globalRateLimit := domain.RateLimit{Rate: rateLimit, Burst: rateLimitBurst}
+	globalQuota := domain.Quota{Images: dailyImageQuota, Bytes: dailyByteQuota}
+
+	// Las compresiones idénticas simultáneas se calculan una vez; la caché va por delante
+	var imageProcessor domain.ImageProcessor = services.NewCoalescingImageProcessor(services.NewImageProcessorService(maxImageSize, metrics))
+	if cacheMaxBytes > 0 {
+		imageProcessor, err = services.NewCachedImageProcessor(imageProcessor, cacheMaxBytes, cacheDir, cacheDiskMaxBytes, metrics)
+		if err != nil {
+			fatal("Error inicializando la caché", "error", err)
+		}
+	}
+	var uploader *services.StorageUploader
+	if storageBackend != "memory" {
+		uploader = services.NewStorageUploader(storage, uploadPrefix, uploadPublicURL)
+	}
+	zipService := services.NewZipService(zipMethod)
+	archives := newArchiveSelector(zipService, services.NewTarService(), services.NewTarGzService())
+	workerPool := services.NewWorkerPool(batchWorkers, services.NewLimiter(maxConcurrent), metrics)
+	webhookNotifier := services.NewWebhookNotifier(webhookSecret, webhookMaxRetries, time.Duration(webhookRetryDelay)*time.Second, time.Duration(webhookTimeout)*time.Second, webhookAllowPrivate)
+	jobService := services.NewJobService(storage, time.Duration(jobTimeout)*time.Second, time.Duration(jobTTL)*time.Second, maxConcurrentJobs, webhookNotifier, publicURL)
 
 	// Configurar router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Timeout(time.Duration(requestTimeout) * time.Second))
+	r.Use(observeRequests(metrics))
 
 	// Rutas
-	r.Get("/health", healthCheck)
-	r.Post("/compress", compressImage(imageProcessor))
-	r.Post("/compress/batch", compressBatch(imageProcessor, zipService, maxBatchSize))
-	r.Post("/compress/info", getImageInfo(imageProcessor))
+	r.With(middleware.Timeout(time.Duration(requestTimeout)*time.Second)).Get("/health", healthCheck)
+
+	// Rutas que requieren API key
+	r.Group(func(r chi.Router) {
+		if apiKeys != nil {
+			r.Use(authenticate(apiKeys))
+		}
+
+		// Consultar el progreso de un trabajo no consume el límite de peticiones: los clientes
+		// lo sondean mientras esperan. El stream de eventos dura lo que el trabajo, así que
+		// tampoco usa REQUEST_TIMEOUT.
+		r.With(middleware.Timeout(time.Duration(requestTimeout)*time.Second)).Get("/jobs/{id}", getJob(jobService))
+		r.Get("/jobs/{id}/events", jobEvents(jobService))
+
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimitRequests(rateLimiter, globalRateLimit))
+			r.Use(middleware.Timeout(time.Duration(requestTimeout) * time.Second))
+
+			// Rutas que consumen la cuota diaria
+			r.Group(func(r chi.Router) {
+				r.Use(enforceQuota(quotas, globalQuota))
+
+				r.Post("/compress", compressImage(imageProcessor, recorder, uploader, presets, maxImageSize))
+				r.Post("/compress/batch", compressBatch(imageProcessor, recorder, workerPool, archives, uploader, maxBatchSize))
+				r.Post("/compress/batch/multipart", compressBatchMultipart(imageProcessor, recorder, workerPool, archives, maxImageSize, maxBatchSize))
+				r.Post("/compress/archive", compressArchive(imageProcessor, recorder, workerPool, zipService, archives, maxArchiveSize, archiveLimits))
+				r.Post("/jobs", createJob(imageProcessor, recorder, workerPool, archives, jobService, presets, maxJobSize, maxJobBodySize))
+			})
+
+			r.Post("/compress/info", getImageInfo(imageProcessor, maxImageSize))
+			r.Get("/jobs/{id}/result", getJobResult(jobService, presets))
+			r.Get("/stats", getStats(usageStats))
+		})
+	})
+
+	// Métricas Prometheus: con METRICS_TOKEN se protegen con ese token y si no, con las API keys
+	r.Group(func(r chi.Router) {
+		switch {
+		case metricsToken != "":
+			r.Use(requireToken(metricsToken))
+		case apiKeys != nil:
+			r.Use(authenticate(apiKeys))
+		}
+		r.Handle("/metrics", metrics.Handler())
+	})
 
 	// Swagger UI
 	swaggerHost := getEnv("SWAGGER_HOST", "localhost:"+port)
@@ -82,15 +370,55 @@ func main() {
 	})
 
 	// Iniciar servidor
-	log.Printf("Servidor iniciado en puerto %s", port)
-	log.Printf("Tamaño máximo de imagen: %d MB", maxImageSize/(1024*1024))
-	log.Printf("Tamaño máximo de lote: %d imágenes", maxBatchSize)
-	log.Printf("Timeout de peticiones: %d segundos", requestTimeout)
-	log.Printf("NOTA: Todo el procesamiento se hace en memoria, sin archivos temporales")
+	slog.Info("Servidor iniciado",
+		"port", port,
+		"log_level", level.String(),
+		"max_image_size", maxImageSize,
+		"max_batch_size", maxBatchSize,
+		"batch_workers", batchWorkers,
+		"max_concurrent_compressions", maxConcurrent,
+		"request_timeout_s", requestTimeout,
+		"max_archive_size", maxArchiveSize,
+		"max_archive_entries", maxArchiveEntries,
+		"storage_backend", storageBackend,
+		"cache_max_bytes", cacheMaxBytes,
+		"cache_dir", cacheDir,
+		"cache_disk_max_bytes", cacheDiskMaxBytes,
+		"presets", presets.Len(),
+		"api_keys", apiKeys.Len(),
+		"rate_limit", rateLimit,
+		"rate_limit_burst", rateLimitBurst,
+		"daily_image_quota", dailyImageQuota,
+		"daily_byte_quota", dailyByteQuota,
+		"tracing", tracerProvider != nil,
+	)
+
+	// Con SIGINT o SIGTERM se dejan terminar las peticiones en curso y se envían los spans pendientes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("Deteniendo servidor")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Error deteniendo servidor", "error", err)
+		}
+		if tracerProvider != nil {
+			if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("Error enviando las trazas pendientes", "error", err)
+			}
+		}
+	}()
 
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("Error iniciando servidor: %v", err)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fatal("Error iniciando servidor", "error", err)
 	}
+	<-stopped
 }
 
 // healthCheck responde con el estado de la API
@@ -118,40 +446,47 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 // @Description Comprime una imagen individual con la calidad y formato especificados
 // @Tags Compression
 // @Accept multipart/form-data
-// @Produce application/octet-stream
+// @Produce application/octet-stream,application/json
 // @Param image formData file true "Archivo de imagen a comprimir"
+// @Param preset formData string false "Preset configurado en PRESETS_FILE: aporta calidad y formato por defecto y el Cache-Control del resultado"
 // @Param quality formData int false "Calidad de compresión (1-100)" default(80)
 // @Param format formData string false "Formato de salida (jpeg, png, webp)" Enums(jpeg, png, webp) default(jpeg)
-// @Success 200 {file} file "Imagen comprimida"
+// @Param if_larger formData string false "Qué hacer si el resultado no es más pequeño (keep, original, error)" Enums(keep, original, error) default(original)
+// @Param upload formData bool false "Guardar la imagen en el almacenamiento y responder con su clave en JSON"
+// @Param If-None-Match header string false "ETag de un resultado anterior; si coincide se responde 304 sin comprimir"
+// @Success 200 {file} file "Imagen comprimida (header X-Compression-Result: compressed|original; X-Format-Fallback: formato real si no es el pedido)"
+// @Success 200 {object} domain.UploadedImage "Imagen guardada en el almacenamiento (upload=true)"
+// @Success 304 {string} string "El resultado no cambió respecto al ETag de If-None-Match"
 // @Failure 400 {string} string "Error en la solicitud"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 403 {string} string "Formato de salida no permitido para la API key"
+// @Failure 413 {string} string "La imagen supera el tamaño máximo (MAX_IMAGE_SIZE o max_image_size de la API key)"
+// @Failure 422 {string} string "El resultado no es más pequeño que la original (if_larger=error)"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
 // @Failure 500 {string} string "Error interno del servidor"
+// @Security ApiKeyAuth
 // @Router /compress [post]
-func compressImage(processor domain.ImageProcessor) http.HandlerFunc {
+func compressImage(processor domain.ImageProcessor, stats domain.StatsRecorder, uploader *services.StorageUploader, presets *services.PresetStore, maxImageSize int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parsear multipart form
-		if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB max
-			http.Error(w, "Error parseando formulario multipart", http.StatusBadRequest)
-			return
-		}
-
-		// Obtener archivo
-		file, _, err := r.FormFile("image")
-		if err != nil {
-			http.Error(w, "Error obteniendo archivo de imagen", http.StatusBadRequest)
+		header, imageData, ok := readImageForm(w, r, maxImageSize)
+		if !ok {
 			return
 		}
-		defer file.Close()
 
-		// Leer datos del archivo
-		imageData, err := io.ReadAll(file)
+		presetName := r.FormValue("preset")
+		preset, err := presets.Get(presetName)
 		if err != nil {
-			http.Error(w, "Error leyendo datos de imagen", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		cacheControl := presets.CacheControl(presetName)
 
-		// Obtener parámetros
+		// Obtener parámetros; los que no se envían toman el valor del preset
 		qualityStr := r.FormValue("quality")
 		quality := 80 // Calidad por defecto
+		if preset.Quality > 0 {
+			quality = preset.Quality
+		}
 		if qualityStr != "" {
 			if q, err := strconv.Atoi(qualityStr); err == nil {
 				quality = q
@@ -160,31 +495,75 @@ func compressImage(processor domain.ImageProcessor) http.HandlerFunc {
 
 		formatStr := r.FormValue("format")
 		format := domain.JPEG // Formato por defecto
+		if preset.Format != "" {
+			format = preset.Format
+		}
 		if formatStr != "" {
 			format = domain.ImageFormat(formatStr)
 		}
 
+		ifLarger := domain.IfLargerPolicy(r.FormValue("if_larger"))
+		if !formatAllowed(w, r, format) {
+			return
+		}
+
+		upload := r.FormValue("upload") == "true"
+		if upload && uploader == nil {
+			http.Error(w, errUploadUnavailable.Error(), http.StatusBadRequest)
+			return
+		}
+
+		start := time.Now()
+
+		// El resultado depende solo de la imagen y las opciones, así que si el cliente ya
+		// lo tiene no hace falta comprimir
+		etag := services.CompressionETag(imageData, quality, format, ifLarger)
+		if !upload && etagMatches(r, etag) {
+			setCacheHeaders(w.Header(), r, etag, cacheControl)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if !quotaAllows(w, r, 1, int64(len(imageData))) {
+			return
+		}
+
 		// Validar imagen
-		if err := processor.ValidateImage(imageData); err != nil {
+		if err := processor.ValidateImage(r.Context(), imageData); err != nil {
+			stats.RecordError(r.Context(), err)
 			http.Error(w, fmt.Sprintf("Imagen inválida: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		// Comprimir imagen
-		compressedData, err := processor.CompressImage(imageData, quality, format)
+		result, err := processor.CompressImage(r.Context(), imageData, quality, format, ifLarger)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error comprimiendo imagen: %v", err), http.StatusInternalServerError)
+			stats.RecordError(r.Context(), err)
+			http.Error(w, fmt.Sprintf("Error comprimiendo imagen: %v", err), compressionErrorStatus(err))
+			return
+		}
+		stats.RecordImage(r.Context(), len(imageData), result)
+
+		logCompressedImage(r.Context(), slog.Default(), header.Filename, len(imageData), result, time.Since(start))
+		if result.Cache != "" {
+			w.Header().Set("X-Cache", string(result.Cache))
+		}
+
+		if upload {
+			uploadImage(w, r, uploader, header.Filename, len(imageData), quality, result)
 			return
 		}
 
 		// Configurar headers para descarga
-		filename := fmt.Sprintf("compressed_%d.%s", time.Now().Unix(), format)
+		filename := fmt.Sprintf("compressed_%d.%s", time.Now().Unix(), result.Format)
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-		w.Header().Set("Content-Length", strconv.Itoa(len(compressedData)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
+		setCompressionResultHeaders(w.Header(), result)
+		setCacheHeaders(w.Header(), r, etag, cacheControl)
 
 		// Escribir datos comprimidos
-		if _, err := w.Write(compressedData); err != nil {
+		if _, err := w.Write(result.Data); err != nil {
 			http.Error(w, "Error escribiendo respuesta", http.StatusInternalServerError)
 			return
 		}
@@ -193,71 +572,360 @@ func compressImage(processor domain.ImageProcessor) http.HandlerFunc {
 
 // compressBatch maneja la compresión de múltiples imágenes
 // @Summary Comprimir múltiples imágenes
-// @Description Comprime múltiples imágenes y las devuelve en un archivo ZIP
+// @Description Comprime múltiples imágenes y las devuelve en un archivo ZIP, TAR o TAR.GZ.
+// @Description El formato se elige con el campo/parámetro archive o, si no se indica, con el header Accept.
 // @Tags Compression
 // @Accept json
-// @Produce application/zip
+// @Produce application/zip,application/x-tar,application/gzip,application/json
 // @Param request body domain.BatchCompressionRequest true "Datos de compresión en lote"
-// @Success 200 {file} file "Archivo ZIP con imágenes comprimidas"
+// @Param archive query string false "Formato del archivo de salida (zip, tar, tar.gz)" Enums(zip, tar, tar.gz) default(zip)
+// @Param stream query bool false "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen"
+// @Param manifest_csv query bool false "Incluir manifest.csv además de manifest.json"
+// @Param on_error query string false "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa" Enums(fail, skip) default(fail)
+// @Param upload query bool false "Guardar cada imagen en el almacenamiento y responder con sus claves en JSON en lugar del archivo"
+// @Success 200 {file} file "Archivo con imágenes comprimidas (header X-Compression-Originals: índices devueltos sin modificar)"
+// @Success 200 {object} domain.UploadResult "Imágenes guardadas en el almacenamiento (upload=true)"
+// @Success 207 {file} file "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json"
 // @Failure 400 {string} string "Error en la solicitud"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 403 {string} string "Formato de salida no permitido para la API key"
+// @Failure 422 {string} string "Alguna imagen no es más pequeña que la original (if_larger=error)"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
 // @Failure 500 {string} string "Error interno del servidor"
+// @Security ApiKeyAuth
 // @Router /compress/batch [post]
-func compressBatch(processor domain.ImageProcessor, zipService domain.ZipService, maxBatchSize int) http.HandlerFunc {
+func compressBatch(processor domain.ImageProcessor, stats domain.StatsRecorder, pool *services.WorkerPool, archives archiveSelector, uploader *services.StorageUploader, maxBatchSize int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req domain.BatchCompressionRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := decodeJSONBody(r, &req); err != nil {
 			http.Error(w, "Error decodificando JSON", http.StatusBadRequest)
 			return
 		}
 
-		// Validar límite de imágenes
-		if len(req.Images) > maxBatchSize {
-			http.Error(w, fmt.Sprintf("Máximo %d imágenes por lote", maxBatchSize), http.StatusBadRequest)
+		// Validar límite de imágenes, el de la API key si lo define
+		key := domain.APIKeyFromContext(r.Context())
+		if limit := key.BatchSizeLimit(maxBatchSize); len(req.Images) > limit {
+			http.Error(w, fmt.Sprintf("Máximo %d imágenes por lote", limit), http.StatusBadRequest)
+			return
+		}
+		if !formatAllowed(w, r, req.Format) || !quotaAllows(w, r, len(req.Images), batchInputBytes(req.Images)) {
+			return
+		}
+
+		archive, err := archives.selectFor(r, req.Archive)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := newBatchResponse(w, r, archive, req.OnError, stats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.manifestCSV = resp.manifestCSV || req.ManifestCSV
+		if r.URL.Query().Get("upload") == "true" {
+			if uploader == nil {
+				http.Error(w, errUploadUnavailable.Error(), http.StatusBadRequest)
+				return
+			}
+			resp.uploadTo(uploader.NewWriter(r.Context()))
+		}
+
+		// Procesar las imágenes en paralelo; los resultados se agregan al archivo en orden
+		batch := services.NewOrderedBatch(r.Context(), pool, resp.emit)
+		defer batch.Stop()
+		if err := submitBatchImages(r.Context(), batch, processor, &req, nil); err != nil {
+			return
+		}
+		if err := batch.Wait(); err != nil {
+			return
+		}
+
+		resp.finish()
+	}
+}
+
+// compressBatchMultipart maneja la compresión de múltiples imágenes enviadas como multipart
+// @Summary Comprimir múltiples imágenes (multipart)
+// @Description Lee cada parte images[] como stream, la comprime y la agrega al archivo de salida sin codificar en base64.
+// @Description Los campos quality, format e if_larger deben enviarse antes que las imágenes.
+// @Tags Compression
+// @Accept multipart/form-data
+// @Produce application/zip,application/x-tar,application/gzip
+// @Param images[] formData file true "Archivos de imagen a comprimir (repetir el campo por cada imagen)"
+// @Param quality formData int false "Calidad de compresión (1-100)" default(80)
+// @Param format formData string false "Formato de salida (jpeg, png, webp)" Enums(jpeg, png, webp) default(jpeg)
+// @Param if_larger formData string false "Qué hacer si el resultado no es más pequeño (keep, original, error)" Enums(keep, original, error) default(original)
+// @Param archive query string false "Formato del archivo de salida (zip, tar, tar.gz)" Enums(zip, tar, tar.gz) default(zip)
+// @Param stream query bool false "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen"
+// @Param manifest_csv query bool false "Incluir manifest.csv además de manifest.json"
+// @Param on_error query string false "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa" Enums(fail, skip) default(fail)
+// @Success 200 {file} file "Archivo con imágenes comprimidas"
+// @Success 207 {file} file "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json"
+// @Failure 400 {string} string "Error en la solicitud"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 403 {string} string "Formato de salida no permitido para la API key"
+// @Failure 422 {string} string "Alguna imagen no es más pequeña que la original (if_larger=error)"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
+// @Failure 500 {string} string "Error interno del servidor"
+// @Security ApiKeyAuth
+// @Router /compress/batch/multipart [post]
+func compressBatchMultipart(processor domain.ImageProcessor, stats domain.StatsRecorder, pool *services.WorkerPool, archives archiveSelector, maxImageSize int64, maxBatchSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Error parseando formulario multipart", http.StatusBadRequest)
+			return
+		}
+
+		archive, err := archives.selectFor(r, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Parámetros por defecto, se sobrescriben con los campos que llegan antes de las imágenes
+		params := defaultCompressionParams()
+
+		// Límites de la API key, si los define
+		key := domain.APIKeyFromContext(r.Context())
+		maxImageSize := key.ImageSizeLimit(maxImageSize)
+		maxBatchSize := key.BatchSizeLimit(maxBatchSize)
+
+		resp, err := newBatchResponse(w, r, archive, "", stats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Procesar cada imagen
-		files := make(map[string][]byte)
-		for i, imgData := range req.Images {
-			// Validar imagen
-			if err := processor.ValidateImage(imgData.Data); err != nil {
-				http.Error(w, fmt.Sprintf("Imagen %d inválida: %v", i+1, err), http.StatusBadRequest)
+		batch := services.NewOrderedBatch(r.Context(), pool, resp.emit)
+		defer batch.Stop()
+		count := 0
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				resp.fail("Error leyendo formulario multipart", http.StatusBadRequest)
+				return
+			}
+
+			if ok, err := params.setFromPart(part); ok {
+				if err != nil {
+					resp.fail("Error leyendo formulario multipart", http.StatusBadRequest)
+					return
+				}
+				continue
+			}
+			if name := part.FormName(); name != "images[]" && name != "images" {
+				// Ignorar campos desconocidos
+				continue
+			}
+
+			// Validar límite de imágenes
+			count++
+			if count > maxBatchSize {
+				resp.fail(fmt.Sprintf("Máximo %d imágenes por lote", maxBatchSize), http.StatusBadRequest)
+				return
+			}
+			if !key.AllowsFormat(params.format) {
+				resp.fail(formatNotAllowedMessage(params.format), http.StatusForbidden)
 				return
 			}
 
-			// Comprimir imagen
-			compressedData, err := processor.CompressImage(imgData.Data, req.Quality, req.Format)
+			// Leer la parte sin superar el tamaño máximo de imagen
+			imageData, err := readPart(r.Context(), part, maxImageSize+1)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Error comprimiendo imagen %d: %v", i+1, err), http.StatusInternalServerError)
+				resp.fail(fmt.Sprintf("Error leyendo imagen %d", count), http.StatusBadRequest)
+				return
+			}
+
+			// El número de imágenes no se conoce hasta leerlas: la cuota se aparta antes de
+			// comprimir cada una
+			if err := reserveQuota(r, 1, int64(len(imageData))); err != nil {
+				resp.fail(err.Error(), http.StatusTooManyRequests)
 				return
 			}
 
-			// Agregar al mapa de archivos
-			filename := imgData.Filename
+			filename := part.FileName()
 			if filename == "" {
-				filename = fmt.Sprintf("image_%d.%s", i+1, req.Format)
+				filename = fmt.Sprintf("image_%d.%s", count, params.format)
+			}
+			item := batchItem{index: count, input: part.FileName(), output: filename, inputSize: len(imageData), quality: params.quality}
+
+			// Comprimir en un worker mientras se lee la siguiente parte
+			params := params
+			if err := batch.Submit(func(ctx context.Context) batchOutcome {
+				return compressBatchImage(ctx, processor, item, imageData, params)
+			}); err != nil {
+				return
+			}
+		}
+		if err := batch.Wait(); err != nil {
+			return
+		}
+
+		if count == 0 {
+			http.Error(w, "No se recibieron imágenes (campo images[])", http.StatusBadRequest)
+			return
+		}
+
+		resp.finish()
+	}
+}
+
+// compressArchive maneja la compresión de las imágenes contenidas en un archivo ZIP
+// @Summary Comprimir un archivo ZIP
+// @Description Recibe un ZIP, comprime cada imagen conservando la estructura de carpetas, copia sin cambios el resto de archivos y devuelve un nuevo ZIP (o TAR/TAR.GZ). Las carpetas vacías y los enlaces simbólicos no se conservan.
+// @Description Los campos quality, format e if_larger deben enviarse antes que el archivo.
+// @Tags Compression
+// @Accept multipart/form-data
+// @Produce application/zip,application/x-tar,application/gzip
+// @Param archive formData file true "Archivo ZIP con imágenes"
+// @Param quality formData int false "Calidad de compresión (1-100)" default(80)
+// @Param format formData string false "Formato de salida (jpeg, png, webp)" Enums(jpeg, png, webp) default(jpeg)
+// @Param if_larger formData string false "Qué hacer si el resultado no es más pequeño (keep, original, error)" Enums(keep, original, error) default(original)
+// @Param archive query string false "Formato del archivo de salida (zip, tar, tar.gz)" Enums(zip, tar, tar.gz) default(zip)
+// @Param stream query bool false "Enviar el archivo en streaming (chunked) a medida que se procesa cada imagen"
+// @Param manifest_csv query bool false "Incluir manifest.csv además de manifest.json"
+// @Param on_error query string false "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa" Enums(fail, skip) default(fail)
+// @Success 200 {file} file "Archivo con imágenes comprimidas"
+// @Success 207 {file} file "Archivo con las imágenes que se pudieron comprimir (on_error=skip); los errores se detallan en manifest.json"
+// @Failure 400 {string} string "Error en la solicitud, ZIP inválido, ruta insegura o más imágenes que el max_batch_size de la API key"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 403 {string} string "Formato de salida no permitido para la API key"
+// @Failure 413 {string} string "El ZIP excede los límites de tamaño o descompresión"
+// @Failure 422 {string} string "Alguna imagen no es más pequeña que la original (if_larger=error)"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
+// @Failure 500 {string} string "Error interno del servidor"
+// @Security ApiKeyAuth
+// @Router /compress/archive [post]
+func compressArchive(processor domain.ImageProcessor, stats domain.StatsRecorder, pool *services.WorkerPool, zipService domain.ZipService, archives archiveSelector, maxArchiveSize int64, limits domain.ZipLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Error parseando formulario multipart", http.StatusBadRequest)
+			return
+		}
+
+		archive, err := archives.selectFor(r, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Leer parámetros y el archivo ZIP
+		params := defaultCompressionParams()
+		var archiveData []byte
+		for archiveData == nil {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, "Error leyendo formulario multipart", http.StatusBadRequest)
+				return
+			}
+
+			if ok, err := params.setFromPart(part); ok {
+				if err != nil {
+					http.Error(w, "Error leyendo formulario multipart", http.StatusBadRequest)
+					return
+				}
+				continue
+			}
+			if part.FormName() != "archive" {
+				continue
+			}
+
+			// El ZIP se necesita completo en memoria para leer su directorio central
+			archiveData, err = readPart(r.Context(), part, maxArchiveSize+1)
+			if err != nil {
+				http.Error(w, "Error leyendo archivo ZIP", http.StatusBadRequest)
+				return
+			}
+			if int64(len(archiveData)) > maxArchiveSize {
+				http.Error(w, fmt.Sprintf("El archivo ZIP supera el máximo de %d bytes", maxArchiveSize), http.StatusRequestEntityTooLarge)
+				return
 			}
-			files[filename] = compressedData
 		}
 
-		// Crear archivo ZIP
-		zipData, err := zipService.CreateZip(files)
+		if archiveData == nil {
+			http.Error(w, "No se recibió el archivo ZIP (campo archive)", http.StatusBadRequest)
+			return
+		}
+		if !formatAllowed(w, r, params.format) {
+			return
+		}
+
+		// Las entradas no pueden superar el tamaño máximo de imagen de la API key y, si la
+		// clave define un tamaño de lote, el archivo no puede tener más imágenes
+		key := domain.APIKeyFromContext(r.Context())
+		limits := limits
+		limits.MaxEntrySize = key.ImageSizeLimit(limits.MaxEntrySize)
+		maxImages := key.BatchSizeLimit(0)
+
+		resp, err := newBatchResponse(w, r, archive, "", stats)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error creando ZIP: %v", err), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		resp.preservePaths = true
 
-		// Configurar headers para descarga
-		zipFilename := fmt.Sprintf("compressed_batch_%d.zip", time.Now().Unix())
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", zipFilename))
-		w.Header().Set("Content-Length", strconv.Itoa(len(zipData)))
+		batch := services.NewOrderedBatch(r.Context(), pool, resp.emit)
+		defer batch.Stop()
+		count, images := 0, 0
+		_, extractSpan := services.StartSpan(r.Context(), "archive.extract", attribute.Int("archive.input_bytes", len(archiveData)))
+		err = zipService.ReadZip(archiveData, limits, func(index int, path string, data []byte) error {
+			count++
+			item := batchItem{index: index, input: path, output: path, inputSize: len(data), quality: params.quality}
 
-		// Escribir datos ZIP
-		if _, err := w.Write(zipData); err != nil {
-			http.Error(w, "Error escribiendo respuesta", http.StatusInternalServerError)
+			// Los archivos que no son imágenes se copian sin cambios. Solo se leen las
+			// cabeceras: la imagen se decodifica una vez, al comprimirla.
+			isImage := services.IsImage(data)
+			if isImage {
+				images++
+				if maxImages > 0 && images > maxImages {
+					return fmt.Errorf("%w: máximo %d imágenes por archivo", domain.ErrBatchSizeExceeded, maxImages)
+				}
+				if err := reserveQuota(r, 1, int64(len(data))); err != nil {
+					return err
+				}
+			}
+			return batch.Submit(func(ctx context.Context) (outcome batchOutcome) {
+				start := time.Now()
+				ctx, span := startBatchImageSpan(ctx, item)
+				defer func() { services.EndSpan(span, outcome.err) }()
+
+				if !isImage {
+					span.SetAttributes(attribute.Bool("batch.raw_copy", true))
+					return batchOutcome{item: item, raw: data}
+				}
+
+				result, err := processor.CompressImage(ctx, data, params.quality, params.format, params.ifLarger)
+				if err != nil {
+					return batchOutcome{item: item, err: err, message: fmt.Sprintf("error comprimiendo %s: %v", path, err), status: compressionErrorStatus(err)}
+				}
+				item.output = domain.OutputFilename(path, result.Format)
+				return batchOutcome{item: item, result: result, duration: time.Since(start)}
+			})
+		})
+		extractSpan.SetAttributes(attribute.Int("archive.entries", count))
+		services.EndSpan(extractSpan, err)
+		if err == nil {
+			err = batch.Wait()
+		}
+		if errors.Is(err, errBatchAborted) {
+			return
+		}
+		if err != nil {
+			resp.fail(err.Error(), archiveErrorStatus(err))
 			return
 		}
+
+		resp.finish()
 	}
 }
 
@@ -270,39 +938,27 @@ func compressBatch(processor domain.ImageProcessor, zipService domain.ZipService
 // @Param image formData file true "Archivo de imagen a analizar"
 // @Success 200 {object} map[string]interface{} "Información de la imagen"
 // @Failure 400 {string} string "Error en la solicitud"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 413 {string} string "La imagen supera el tamaño máximo (MAX_IMAGE_SIZE o max_image_size de la API key)"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
 // @Failure 500 {string} string "Error interno del servidor"
+// @Security ApiKeyAuth
 // @Router /compress/info [post]
-func getImageInfo(processor domain.ImageProcessor) http.HandlerFunc {
+func getImageInfo(processor domain.ImageProcessor, maxImageSize int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parsear multipart form
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			http.Error(w, "Error parseando formulario multipart", http.StatusBadRequest)
-			return
-		}
-
-		// Obtener archivo
-		file, header, err := r.FormFile("image")
-		if err != nil {
-			http.Error(w, "Error obteniendo archivo de imagen", http.StatusBadRequest)
-			return
-		}
-		defer file.Close()
-
-		// Leer datos del archivo
-		imageData, err := io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "Error leyendo datos de imagen", http.StatusBadRequest)
+		header, imageData, ok := readImageForm(w, r, maxImageSize)
+		if !ok {
 			return
 		}
 
 		// Validar imagen
-		if err := processor.ValidateImage(imageData); err != nil {
+		if err := processor.ValidateImage(r.Context(), imageData); err != nil {
 			http.Error(w, fmt.Sprintf("Imagen inválida: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		// Obtener información
-		width, height, format, err := processor.GetImageInfo(imageData)
+		width, height, format, err := processor.GetImageInfo(r.Context(), imageData)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error obteniendo información: %v", err), http.StatusInternalServerError)
 			return
@@ -322,6 +978,1367 @@ func getImageInfo(processor domain.ImageProcessor) http.HandlerFunc {
 	}
 }
 
+// multipartOverhead son los bytes que se admiten en un formulario, además de la imagen, para
+// los demás campos y las cabeceras de cada parte
+const multipartOverhead = 1 << 20
+
+// readImageForm lee el archivo del campo image de un formulario multipart. El cuerpo se limita
+// al tamaño máximo de imagen de la API key (o maxImageSize) más multipartOverhead, para no
+// leer a disco formularios que se van a rechazar. Si falla responde con el error y devuelve ok
+// en false.
+func readImageForm(w http.ResponseWriter, r *http.Request, maxImageSize int64) (header *multipart.FileHeader, imageData []byte, ok bool) {
+	_, span := services.StartSpan(r.Context(), "multipart.parse")
+	var err error
+	defer func() { services.EndSpan(span, err) }()
+
+	// Parsear multipart form
+	maxImageSize = domain.APIKeyFromContext(r.Context()).ImageSizeLimit(maxImageSize)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+multipartOverhead)
+	if err = r.ParseMultipartForm(32 << 20); err != nil { // Hasta 32MB en memoria, el resto en disco
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("La imagen supera el máximo de %d bytes", maxImageSize), http.StatusRequestEntityTooLarge)
+			return nil, nil, false
+		}
+		http.Error(w, "Error parseando formulario multipart", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// Obtener archivo
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error obteniendo archivo de imagen", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	defer file.Close()
+
+	// Leer datos del archivo
+	imageData, err = io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error leyendo datos de imagen", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	span.SetAttributes(attribute.String("multipart.filename", header.Filename), attribute.Int("multipart.file_bytes", len(imageData)))
+	return header, imageData, true
+}
+
+// createJob crea un trabajo asíncrono para comprimir un lote grande
+// @Summary Crear un trabajo de compresión en lote
+// @Description Acepta el mismo cuerpo que /compress/batch, responde de inmediato con el ID del trabajo y procesa el lote en segundo plano, sin el límite de REQUEST_TIMEOUT.
+// @Description Si se indica callback_url, al terminar se envía a esa URL un POST JSON firmado (header X-Webhook-Signature) con el estado, las estadísticas por imagen y el enlace de descarga. La URL debe resolver a direcciones públicas y requiere WEBHOOK_SECRET; si no, se responde 400.
+// @Tags Jobs
+// @Accept json
+// @Produce json
+// @Param request body domain.JobRequest true "Datos de compresión en lote, callback_url y preset opcionales"
+// @Param archive query string false "Formato del archivo de salida (zip, tar, tar.gz)" Enums(zip, tar, tar.gz) default(zip)
+// @Param manifest_csv query bool false "Incluir manifest.csv además de manifest.json"
+// @Param on_error query string false "Qué hacer si falla una imagen: fail aborta el lote, skip la registra en el manifiesto y continúa" Enums(fail, skip) default(fail)
+// @Success 202 {object} domain.Job "Trabajo creado (header Location: URL de estado)"
+// @Failure 400 {string} string "Error en la solicitud"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 403 {string} string "Formato de salida no permitido para la API key"
+// @Failure 413 {string} string "El cuerpo supera MAX_JOB_BODY_SIZE"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
+// @Failure 503 {string} string "Ya hay MAX_CONCURRENT_JOBS trabajos en curso (ver Retry-After)"
+// @Security ApiKeyAuth
+// @Router /jobs [post]
+func createJob(processor domain.ImageProcessor, stats domain.StatsRecorder, pool *services.WorkerPool, archives archiveSelector, jobs *services.JobService, presets *services.PresetStore, maxJobSize int, maxBodySize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// El lote se decodifica entero en memoria y se conserva hasta que termina el trabajo
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		var req domain.JobRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("El cuerpo del trabajo supera el máximo de %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Error decodificando JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := jobs.ValidateCallbackURL(r.Context(), req.CallbackURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Las opciones que no se envían toman el valor del preset
+		preset, err := presets.Get(req.Preset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Quality == 0 {
+			req.Quality = preset.Quality
+		}
+		if req.Format == "" {
+			req.Format = preset.Format
+		}
+
+		if len(req.Images) == 0 {
+			http.Error(w, "No se recibieron imágenes", http.StatusBadRequest)
+			return
+		}
+		// Validar límite de imágenes, el de la API key si lo define
+		if limit := domain.APIKeyFromContext(r.Context()).BatchSizeLimit(maxJobSize); len(req.Images) > limit {
+			http.Error(w, fmt.Sprintf("Máximo %d imágenes por trabajo", limit), http.StatusBadRequest)
+			return
+		}
+		if !formatAllowed(w, r, req.Format) || !quotaAllows(w, r, len(req.Images), batchInputBytes(req.Images)) {
+			return
+		}
+
+		archive, err := archives.selectFor(r, req.Archive)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		batch, err := newBatchArchive(r, archive, req.OnError, stats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batch.manifestCSV = batch.manifestCSV || req.ManifestCSV
+
+		// La cuota apartada sigue reservada hasta que termina el trabajo, no la petición
+		reservation := services.QuotaReservationFromContext(r.Context()).Transfer()
+		job, err := jobs.Start(domain.Job{Total: len(req.Images), CallbackURL: req.CallbackURL, Preset: req.Preset, Owner: jobOwner(r)}, runBatchJob(processor, pool, batch, &req.BatchCompressionRequest, reservation))
+		if err != nil {
+			reservation.Release()
+			w.Header().Set("Retry-After", strconv.Itoa(jobRetryAfter))
+			http.Error(w, err.Error(), jobErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// jobRetryAfter son los segundos que se indican en Retry-After cuando no se aceptan más trabajos
+const jobRetryAfter = 10
+
+// runBatchJob devuelve la función que procesa el lote de un trabajo en segundo plano. El
+// trabajo conserva la traza, la API key y el cliente de la petición que lo creó, guardados
+// en batch.ctx, y libera al terminar la cuota que apartó (reservation).
+func runBatchJob(processor domain.ImageProcessor, pool *services.WorkerPool, batch *batchArchive, req *domain.BatchCompressionRequest, reservation *services.QuotaReservation) services.JobFunc {
+	return func(ctx context.Context, report func(event domain.JobEvent)) (result *domain.JobResult, err error) {
+		defer reservation.Release()
+		ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(batch.ctx))
+		ctx = domain.WithAPIKey(ctx, domain.APIKeyFromContext(batch.ctx))
+		ctx = domain.WithClient(ctx, domain.ClientFromContext(batch.ctx))
+		ctx, span := services.StartSpan(ctx, "job.run", attribute.Int("batch.size", len(req.Images)))
+		defer func() { services.EndSpan(span, err) }()
+
+		var buf bytes.Buffer
+		batch.ctx = ctx
+		batch.writer = batch.archive.NewWriter(&buf)
+
+		images := services.NewOrderedBatch(ctx, pool, func(outcome batchOutcome, cancelErr error) error {
+			if cancelErr != nil {
+				return cancelErr
+			}
+			return batch.apply(outcome)
+		})
+		defer images.Stop()
+		if err := submitBatchImages(ctx, images, processor, req, report); err != nil {
+			return nil, err
+		}
+		if err := images.Wait(); err != nil {
+			// El manifiesto parcial se incluye en la notificación del fallo
+			return &domain.JobResult{Manifest: &batch.manifest}, err
+		}
+
+		if err := batch.close(); err != nil {
+			return nil, err
+		}
+
+		return &domain.JobResult{
+			Data:        buf.Bytes(),
+			ContentType: batch.archive.ContentType(),
+			Filename:    fmt.Sprintf("compressed_batch_%d.%s", time.Now().Unix(), batch.archive.Format()),
+			Originals:   batch.originals,
+			Manifest:    &batch.manifest,
+		}, nil
+	}
+}
+
+// getJob devuelve el estado y el progreso de un trabajo
+// @Summary Estado de un trabajo
+// @Description Devuelve el estado (queued, running, completed, failed) y el progreso de un trabajo
+// @Tags Jobs
+// @Produce json
+// @Param id path string true "ID del trabajo"
+// @Success 200 {object} domain.Job "Estado del trabajo"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 404 {string} string "Trabajo no encontrado o creado con otra API key"
+// @Security ApiKeyAuth
+// @Router /jobs/{id} [get]
+func getJob(jobs *services.JobService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobs.Get(chi.URLParam(r, "id"), jobOwner(r))
+		if err != nil {
+			http.Error(w, err.Error(), jobErrorStatus(err))
+			return
+		}
+
+		if job.Status == domain.JobCompleted {
+			job.ResultURL = services.JobResultPath(job.ID)
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// jobEvents envía el progreso de un trabajo como Server-Sent Events
+// @Summary Progreso de un trabajo (SSE)
+// @Description Stream text/event-stream con un evento por imagen (image.started, image.completed, image.failed) con el ahorro en bytes, y un evento final job.completed o job.failed.
+// @Description Los eventos ya ocurridos se envían al conectarse; con el header Last-Event-ID se reanuda tras el último recibido.
+// @Tags Jobs
+// @Produce text/event-stream
+// @Param id path string true "ID del trabajo"
+// @Success 200 {object} domain.JobEvent "Stream de eventos"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 404 {string} string "Trabajo no encontrado o creado con otra API key"
+// @Security ApiKeyAuth
+// @Router /jobs/{id}/events [get]
+func jobEvents(jobs *services.JobService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Reanudar después del último evento recibido por el cliente
+		lastEventID, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+		if err != nil || lastEventID < 0 {
+			lastEventID = 0
+		}
+
+		if err := jobs.WriteEvents(r.Context(), w, chi.URLParam(r, "id"), jobOwner(r), lastEventID, jobEventsHeartbeat); err != nil {
+			http.Error(w, err.Error(), jobErrorStatus(err))
+		}
+	}
+}
+
+// jobEventsHeartbeat es cada cuánto se envía un comentario por /jobs/{id}/events mientras no
+// hay eventos, para que los proxies no cierren la conexión inactiva
+const jobEventsHeartbeat = 15 * time.Second
+
+// getJobResult descarga el archivo generado por un trabajo terminado
+// @Summary Resultado de un trabajo
+// @Description Descarga el archivo (ZIP, TAR o TAR.GZ) generado por un trabajo terminado. El header Cache-Control es el del preset del trabajo, con max-age limitado al tiempo que le queda al trabajo antes de eliminarse y private si la petición usa una API key.
+// @Tags Jobs
+// @Produce application/zip,application/x-tar,application/gzip
+// @Param id path string true "ID del trabajo"
+// @Param If-None-Match header string false "ETag de una descarga anterior; si coincide se responde 304"
+// @Success 200 {file} file "Archivo con imágenes comprimidas"
+// @Success 207 {file} file "Archivo con las imágenes que se pudieron comprimir (on_error=skip)"
+// @Success 304 {string} string "El archivo no cambió respecto al ETag de If-None-Match"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 404 {string} string "Trabajo no encontrado o creado con otra API key"
+// @Failure 409 {string} string "El trabajo aún no ha terminado o terminó con error"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
+// @Security ApiKeyAuth
+// @Router /jobs/{id}/result [get]
+func getJobResult(jobs *services.JobService, presets *services.PresetStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		owner := jobOwner(r)
+		job, err := jobs.Get(id, owner)
+		if err != nil {
+			http.Error(w, err.Error(), jobErrorStatus(err))
+			return
+		}
+		reader, result, err := jobs.OpenResult(r.Context(), id, owner)
+		if err != nil {
+			http.Error(w, err.Error(), jobErrorStatus(err))
+			return
+		}
+		defer reader.Close()
+
+		// El resultado se elimina JOB_TTL después de terminar el trabajo: no debe seguir en caché
+		cacheControl := services.CapMaxAge(presets.CacheControl(job.Preset), time.Until(jobs.ExpiresAt(job)))
+		setCacheHeaders(w.Header(), r, result.ETag, cacheControl)
+		if etagMatches(r, result.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		setBatchResultHeaders(w.Header(), result.Originals, result.Manifest)
+		w.Header().Set("Content-Type", result.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", result.Filename))
+		w.Header().Set("Content-Length", strconv.FormatInt(result.Size, 10))
+		if result.Manifest.Failed > 0 {
+			w.WriteHeader(http.StatusMultiStatus)
+		}
+
+		if _, err := io.Copy(w, reader); err != nil {
+			slog.WarnContext(r.Context(), "Error escribiendo resultado del trabajo", "error", err)
+		}
+	}
+}
+
+// getStats devuelve las estadísticas de uso acumuladas desde el arranque. Con autenticación
+// cada clave ve solo su propio uso; las claves admin ven el total y el desglose por clave.
+// @Summary Estadísticas de uso
+// @Description Devuelve los contadores acumulados desde que arrancó el servidor: imágenes procesadas (incluidas las servidas desde la caché), bytes ahorrados, relación media por formato de salida y los errores más frecuentes. Con API keys, cada clave recibe solo sus propios contadores; el total y el desglose por clave (keys) requieren una clave con admin.
+// @Tags General
+// @Produce json
+// @Success 200 {object} domain.UsageStats "Estadísticas de uso"
+// @Failure 401 {string} string "API key inválida o ausente"
+// @Failure 429 {string} string "Límite de peticiones o cuota diaria agotados (ver Retry-After)"
+// @Security ApiKeyAuth
+// @Router /stats [get]
+func getStats(stats *services.UsageStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := domain.APIKeyFromContext(r.Context()); key != nil && !key.Admin {
+			writeJSON(w, http.StatusOK, stats.KeySnapshot(key.Name))
+			return
+		}
+		writeJSON(w, http.StatusOK, stats.Snapshot())
+	}
+}
+
+// batchArchive construye el archivo (ZIP, TAR...) de un lote y su manifiesto, con
+// independencia de cómo se entregue: en la respuesta HTTP o como resultado de un trabajo.
+type batchArchive struct {
+	archive       domain.ArchiveService
+	writer        domain.ArchiveWriter
+	preservePaths bool                 // Conservar carpetas en los nombres de las entradas
+	manifestCSV   bool                 // Incluir también manifest.csv
+	onError       domain.ErrorPolicy   // Abortar o continuar cuando falla una imagen
+	beforeWrite   func()               // Se llama antes de escribir cada entrada
+	logger        *slog.Logger         // Logger con el request_id de la petición que creó el lote
+	ctx           context.Context      // Contexto del que cuelgan los spans de cada entrada
+	stats         domain.StatsRecorder // Recibe el resultado de cada imagen para /stats
+	originals     []string
+	manifest      domain.BatchManifest
+}
+
+// batchItem identifica una imagen del lote y su nombre en el archivo de salida
+type batchItem struct {
+	index     int // Posición en el lote (o en el ZIP subido), empezando en 1
+	input     string
+	output    string
+	inputSize int
+	quality   int
+}
+
+// newBatchArchive crea el archivo de un lote según los parámetros manifest_csv y on_error
+// de la URL. onError, si no está vacío, tiene prioridad sobre el de la URL.
+// El writer lo asigna quien decide el destino del archivo.
+func newBatchArchive(r *http.Request, archive domain.ArchiveService, onError domain.ErrorPolicy, stats domain.StatsRecorder) (*batchArchive, error) {
+	if onError == "" {
+		onError = domain.ErrorPolicy(r.URL.Query().Get("on_error"))
+	}
+	switch onError {
+	case "":
+		onError = domain.OnErrorFail
+	case domain.OnErrorFail, domain.OnErrorSkip:
+	default:
+		return nil, domain.ErrInvalidErrorPolicy
+	}
+
+	return &batchArchive{
+		archive:     archive,
+		manifestCSV: r.URL.Query().Get("manifest_csv") == "true",
+		onError:     onError,
+		logger:      requestLogger(r),
+		ctx:         r.Context(),
+		stats:       stats,
+		manifest:    domain.BatchManifest{Images: []domain.ManifestEntry{}},
+	}, nil
+}
+
+// add agrega la imagen comprimida al archivo y la registra en el manifiesto
+func (b *batchArchive) add(item batchItem, result *domain.CompressionResult) error {
+	if result.Original {
+		b.originals = append(b.originals, strconv.Itoa(item.index))
+	}
+
+	output, err := b.write(item.output, result.Data)
+	if err != nil {
+		return err
+	}
+
+	item.output = output
+	b.manifest.Images = append(b.manifest.Images, newManifestEntry(item, result))
+	b.manifest.Succeeded++
+	return nil
+}
+
+// newManifestEntry describe en el manifiesto una imagen comprimida
+func newManifestEntry(item batchItem, result *domain.CompressionResult) domain.ManifestEntry {
+	ratio := 0.0
+	if item.inputSize > 0 {
+		ratio = math.Round(float64(len(result.Data))/float64(item.inputSize)*10000) / 10000
+	}
+	return domain.ManifestEntry{
+		Input:      item.input,
+		Output:     item.output,
+		InputSize:  int64(item.inputSize),
+		OutputSize: int64(len(result.Data)),
+		Ratio:      ratio,
+		Width:      result.Width,
+		Height:     result.Height,
+		Format:     result.Format,
+		Quality:    item.quality,
+		Original:   result.Original,
+		Warnings:   result.Warnings,
+	}
+}
+
+// errBatchAborted indica que el lote se detuvo porque ya se respondió con un error
+var errBatchAborted = errors.New("lote abortado")
+
+// errUploadUnavailable indica que se pidió upload=true con el almacenamiento en memoria,
+// donde los objetos no serían accesibles desde fuera del servidor
+var errUploadUnavailable = errors.New("upload=true requiere STORAGE_BACKEND=fs o s3")
+
+// batchFailure es el error que detiene un lote, con el mensaje y el código HTTP para el cliente
+type batchFailure struct {
+	message string
+	status  int
+}
+
+func (f *batchFailure) Error() string {
+	return f.message
+}
+
+// batchOutcome es el resultado de procesar una imagen del lote en un worker
+type batchOutcome struct {
+	item     batchItem
+	result   *domain.CompressionResult
+	raw      []byte // Archivo que no es una imagen y se copia sin cambios
+	err      error
+	message  string // Mensaje para el cliente si err no es nil
+	status   int
+	duration time.Duration // Tiempo de validación y compresión
+}
+
+// compressBatchImage valida y comprime una imagen del lote. Se ejecuta en un worker.
+func compressBatchImage(ctx context.Context, processor domain.ImageProcessor, item batchItem, data []byte, params compressionParams) (outcome batchOutcome) {
+	start := time.Now()
+	ctx, span := startBatchImageSpan(ctx, item)
+	defer func() { services.EndSpan(span, outcome.err) }()
+
+	// Validar imagen
+	if err := processor.ValidateImage(ctx, data); err != nil {
+		return batchOutcome{item: item, err: err, message: fmt.Sprintf("Imagen %d inválida: %v", item.index, err), status: http.StatusBadRequest}
+	}
+
+	// Comprimir imagen
+	result, err := processor.CompressImage(ctx, data, params.quality, params.format, params.ifLarger)
+	if err != nil {
+		return batchOutcome{item: item, err: err, message: fmt.Sprintf("Error comprimiendo imagen %d: %v", item.index, err), status: compressionErrorStatus(err)}
+	}
+
+	// La extensión debe coincidir con el formato realmente generado
+	item.output = domain.OutputFilename(item.output, result.Format)
+	return batchOutcome{item: item, result: result, duration: time.Since(start)}
+}
+
+// startBatchImageSpan inicia el span del procesamiento de una imagen del lote
+func startBatchImageSpan(ctx context.Context, item batchItem) (context.Context, trace.Span) {
+	return services.StartSpan(ctx, "batch.image",
+		attribute.Int("batch.index", item.index),
+		attribute.String("batch.input", item.input),
+		attribute.Int("image.input_bytes", item.inputSize),
+	)
+}
+
+// submitBatchImages envía a batch las imágenes de una solicitud de compresión en lote (JSON).
+// Si report no es nil recibe los eventos de progreso de cada imagen desde los workers.
+func submitBatchImages(ctx context.Context, batch *services.OrderedBatch[batchOutcome], processor domain.ImageProcessor, req *domain.BatchCompressionRequest, report func(event domain.JobEvent)) error {
+	params := compressionParams{quality: req.Quality, format: req.Format, ifLarger: req.IfLarger}
+	for i, imgData := range req.Images {
+		filename := imgData.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("image_%d.%s", i+1, req.Format)
+		}
+		item := batchItem{index: i + 1, input: imgData.Filename, output: filename, inputSize: len(imgData.Data), quality: req.Quality}
+
+		data := imgData.Data
+		if err := batch.Submit(func(ctx context.Context) batchOutcome {
+			if report == nil {
+				return compressBatchImage(ctx, processor, item, data, params)
+			}
+
+			report(domain.JobEvent{Type: domain.JobEventImageStarted, Index: item.index, Input: item.input, InputSize: int64(item.inputSize)})
+			outcome := compressBatchImage(ctx, processor, item, data, params)
+			report(outcome.event())
+			return outcome
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event devuelve el evento de progreso correspondiente al resultado de una imagen
+func (o batchOutcome) event() domain.JobEvent {
+	event := domain.JobEvent{
+		Type:      domain.JobEventImageCompleted,
+		Index:     o.item.index,
+		Input:     o.item.input,
+		InputSize: int64(o.item.inputSize),
+	}
+	if o.err != nil {
+		event.Type = domain.JobEventImageFailed
+		event.Error = o.err.Error()
+		event.ErrorCode = domain.ErrorCode(o.err)
+		return event
+	}
+
+	event.Output = o.item.output
+	event.OutputSize = o.result.Size
+	event.SavedBytes = event.InputSize - event.OutputSize
+	if event.InputSize > 0 {
+		event.Ratio = math.Round(float64(event.OutputSize)/float64(event.InputSize)*10000) / 10000
+	}
+	return event
+}
+
+// apply agrega al archivo el resultado de una imagen, en el orden del lote, o aplica la
+// política on_error si falló. Devuelve un *batchFailure si el lote debe detenerse.
+func (b *batchArchive) apply(outcome batchOutcome) error {
+	if outcome.err != nil {
+		b.stats.RecordError(b.ctx, outcome.err)
+		if b.skip(outcome.item, outcome.err) {
+			return nil
+		}
+		return &batchFailure{message: outcome.message, status: outcome.status}
+	}
+
+	var err error
+	if outcome.raw != nil {
+		err = b.copy(outcome.item.input, outcome.raw)
+	} else {
+		err = b.add(outcome.item, outcome.result)
+	}
+	if err != nil {
+		return &batchFailure{message: fmt.Sprintf("Error creando archivo: %v", err), status: http.StatusInternalServerError}
+	}
+
+	if outcome.result != nil {
+		b.stats.RecordImage(b.ctx, outcome.item.inputSize, outcome.result)
+		logCompressedImage(context.Background(), b.logger, outcome.item.input, outcome.item.inputSize, outcome.result, outcome.duration)
+	} else {
+		b.logger.Debug("Archivo copiado sin cambios", "input", outcome.item.input, "input_bytes", outcome.item.inputSize)
+	}
+	return nil
+}
+
+// skip registra en el manifiesto el error de una imagen si la política es on_error=skip.
+// Devuelve false si el lote debe abortarse.
+func (b *batchArchive) skip(item batchItem, err error) bool {
+	if b.onError != domain.OnErrorSkip {
+		return false
+	}
+
+	b.logger.Warn("Imagen omitida del lote", "input", item.input, "error", err, "error_code", domain.ErrorCode(err))
+	b.manifest.Images = append(b.manifest.Images, domain.ManifestEntry{
+		Input:     item.input,
+		InputSize: int64(item.inputSize),
+		Quality:   item.quality,
+		Error:     err.Error(),
+		ErrorCode: domain.ErrorCode(err),
+	})
+	b.manifest.Failed++
+	return true
+}
+
+// copy agrega al archivo un fichero que no es una imagen, sin modificarlo
+func (b *batchArchive) copy(filename string, data []byte) error {
+	_, err := b.write(filename, data)
+	return err
+}
+
+// write escribe una entrada en el archivo. Devuelve el nombre final de la entrada.
+func (b *batchArchive) write(filename string, data []byte) (output string, err error) {
+	_, span := services.StartSpan(b.ctx, "archive.add",
+		attribute.String("archive.format", string(b.archive.Format())),
+		attribute.String("archive.entry", filename),
+		attribute.Int("archive.entry_bytes", len(data)),
+	)
+	defer func() { services.EndSpan(span, err) }()
+
+	if b.beforeWrite != nil {
+		b.beforeWrite()
+	}
+
+	if b.preservePaths {
+		return b.writer.AddPath(filename, data)
+	}
+	return b.writer.AddFile(filename, data)
+}
+
+// close agrega el manifiesto al final del archivo y lo cierra
+func (b *batchArchive) close() (err error) {
+	_, span := services.StartSpan(b.ctx, "archive.close",
+		attribute.String("archive.format", string(b.archive.Format())),
+		attribute.Int("archive.entries", len(b.manifest.Images)),
+	)
+	defer func() { services.EndSpan(span, err) }()
+
+	if b.beforeWrite != nil {
+		b.beforeWrite()
+	}
+
+	if err := b.writer.AddManifest(&b.manifest, b.manifestCSV); err != nil {
+		return &batchFailure{message: fmt.Sprintf("Error creando manifiesto: %v", err), status: http.StatusInternalServerError}
+	}
+	if err := b.writer.Close(); err != nil {
+		return &batchFailure{message: fmt.Sprintf("Error creando archivo: %v", err), status: http.StatusInternalServerError}
+	}
+	return nil
+}
+
+// setBatchResultHeaders configura los headers con el resumen del lote
+func setBatchResultHeaders(header http.Header, originals []string, manifest *domain.BatchManifest) {
+	if len(originals) > 0 {
+		header.Set("X-Compression-Originals", strings.Join(originals, ","))
+	}
+	header.Set("X-Batch-Succeeded", strconv.Itoa(manifest.Succeeded))
+	header.Set("X-Batch-Failed", strconv.Itoa(manifest.Failed))
+}
+
+// batchResponse escribe el archivo de un lote en la respuesta, ya sea completo en memoria o en streaming.
+// En streaming cada entrada se envía al cliente en cuanto se agrega (transferencia chunked),
+// por lo que la información que se conoce al final viaja en trailers HTTP.
+type batchResponse struct {
+	*batchArchive
+	w       http.ResponseWriter
+	stream  bool
+	buf     bytes.Buffer
+	started bool
+	upload  *services.UploadWriter // Si no es nil las imágenes se guardan en el almacenamiento
+}
+
+// newBatchResponse crea la respuesta de un lote según los parámetros stream, manifest_csv
+// y on_error de la URL. onError, si no está vacío, tiene prioridad sobre el de la URL.
+func newBatchResponse(w http.ResponseWriter, r *http.Request, archive domain.ArchiveService, onError domain.ErrorPolicy, stats domain.StatsRecorder) (*batchResponse, error) {
+	batch, err := newBatchArchive(r, archive, onError, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &batchResponse{
+		batchArchive: batch,
+		w:            w,
+		stream:       r.URL.Query().Get("stream") == "true",
+	}
+	batch.beforeWrite = resp.begin
+	if resp.stream {
+		// Permitir seguir leyendo el cuerpo (p. ej. multipart) mientras se envía la respuesta en HTTP/1.1
+		_ = http.NewResponseController(w).EnableFullDuplex()
+		batch.writer = archive.NewStreamWriter(w)
+	} else {
+		batch.writer = archive.NewWriter(&resp.buf)
+	}
+	return resp, nil
+}
+
+// uploadTo guarda cada imagen en el almacenamiento a través de writer en lugar de
+// agregarla al archivo; la respuesta será el JSON con las claves de los objetos
+func (b *batchResponse) uploadTo(writer *services.UploadWriter) {
+	b.upload = writer
+	b.writer = writer
+	b.stream = false
+}
+
+// emit agrega al archivo el resultado de una imagen, en el orden del lote, o aplica la
+// política on_error si falló. cancelErr indica que la tarea no llegó a ejecutarse.
+// Devuelve errBatchAborted si ya se respondió con un error.
+func (b *batchResponse) emit(outcome batchOutcome, cancelErr error) error {
+	if cancelErr != nil {
+		b.fail("Petición cancelada", http.StatusServiceUnavailable)
+		return errBatchAborted
+	}
+
+	var failure *batchFailure
+	if err := b.apply(outcome); errors.As(err, &failure) {
+		b.fail(failure.message, failure.status)
+		return errBatchAborted
+	}
+	return nil
+}
+
+// begin envía los headers antes de la primera entrada si la respuesta es en streaming
+func (b *batchResponse) begin() {
+	if b.stream && !b.started {
+		b.setDownloadHeaders()
+		b.w.Header().Set("Trailer", "X-Compression-Originals, X-Batch-Succeeded, X-Batch-Failed")
+		b.w.WriteHeader(http.StatusOK)
+	}
+	b.started = true
+}
+
+// fail responde con un error. Si el streaming ya comenzó no es posible cambiar el
+// código de estado, así que se aborta la conexión para que el cliente no reciba un archivo truncado como válido.
+func (b *batchResponse) fail(message string, status int) {
+	if b.stream && b.started {
+		b.logger.Warn("Abortando lote en streaming", "error", message, "status", status)
+		panic(http.ErrAbortHandler)
+	}
+	if b.upload != nil {
+		b.upload.Discard()
+	}
+	http.Error(b.w, message, status)
+}
+
+// finish cierra el archivo y envía lo que falte de la respuesta
+func (b *batchResponse) finish() {
+	if !b.started && len(b.manifest.Images) == 0 {
+		b.fail("No se recibieron imágenes", http.StatusBadRequest)
+		return
+	}
+
+	// Agregar el manifiesto y cerrar el archivo
+	var failure *batchFailure
+	if err := b.close(); errors.As(err, &failure) {
+		b.fail(failure.message, failure.status)
+		return
+	}
+
+	// En streaming estos headers viajan como trailers
+	setBatchResultHeaders(b.w.Header(), b.originals, &b.manifest)
+	if b.stream {
+		return
+	}
+	if b.upload != nil {
+		b.finishUpload()
+		return
+	}
+
+	// Configurar headers para descarga
+	b.setDownloadHeaders()
+	b.w.Header().Set("Content-Length", strconv.Itoa(b.buf.Len()))
+
+	// Con on_error=skip, 207 indica que algunas imágenes fallaron (detalle en el manifiesto)
+	if b.manifest.Failed > 0 {
+		b.w.WriteHeader(http.StatusMultiStatus)
+	}
+
+	// Escribir datos del archivo
+	if _, err := b.w.Write(b.buf.Bytes()); err != nil {
+		http.Error(b.w, "Error escribiendo respuesta", http.StatusInternalServerError)
+		return
+	}
+}
+
+// finishUpload responde con los objetos guardados en el almacenamiento (upload=true)
+func (b *batchResponse) finishUpload() {
+	result := domain.UploadResult{
+		Succeeded: b.manifest.Succeeded,
+		Failed:    b.manifest.Failed,
+		Images:    make([]domain.UploadedImage, 0, len(b.manifest.Images)),
+	}
+	for _, entry := range b.manifest.Images {
+		image := domain.UploadedImage{ManifestEntry: entry}
+		if object := b.upload.Object(entry.Output); entry.Error == "" && object != nil {
+			image.Key = object.Key
+			image.ETag = object.ETag
+			image.URL = b.upload.URL(object.Key)
+		}
+		result.Images = append(result.Images, image)
+	}
+
+	// Con on_error=skip, 207 indica que algunas imágenes fallaron
+	status := http.StatusOK
+	if b.manifest.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeJSON(b.w, status, result)
+}
+
+// uploadImage guarda en el almacenamiento una imagen comprimida con /compress y responde
+// con su clave, tamaño y ETag
+func uploadImage(w http.ResponseWriter, r *http.Request, uploader *services.StorageUploader, filename string, inputSize, quality int, result *domain.CompressionResult) {
+	if filename == "" {
+		filename = "image"
+	}
+	item := batchItem{index: 1, input: filename, output: domain.OutputFilename(filename, result.Format), inputSize: inputSize, quality: quality}
+
+	object, err := uploader.Upload(r.Context(), item.output, result.Data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error guardando imagen: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	item.output = path.Base(object.Key)
+	setCompressionResultHeaders(w.Header(), result)
+	writeJSON(w, http.StatusOK, domain.UploadedImage{
+		ManifestEntry: newManifestEntry(item, result),
+		Key:           object.Key,
+		ETag:          object.ETag,
+		URL:           uploader.URL(object.Key),
+	})
+}
+
+// setDownloadHeaders configura los headers de descarga del archivo
+func (b *batchResponse) setDownloadHeaders() {
+	archiveFilename := fmt.Sprintf("compressed_batch_%d.%s", time.Now().Unix(), b.archive.Format())
+	b.w.Header().Set("Content-Type", b.archive.ContentType())
+	b.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", archiveFilename))
+}
+
+// archiveSelector elige el servicio de archivo de salida de un lote según su formato
+type archiveSelector map[domain.ArchiveFormat]domain.ArchiveService
+
+// newArchiveSelector registra los servicios de archivo disponibles
+func newArchiveSelector(archives ...domain.ArchiveService) archiveSelector {
+	selector := make(archiveSelector, len(archives))
+	for _, archive := range archives {
+		selector[archive.Format()] = archive
+	}
+	return selector
+}
+
+// archiveMediaTypes relaciona los tipos MIME del header Accept con los formatos de archivo
+var archiveMediaTypes = map[string]domain.ArchiveFormat{
+	"application/zip":              domain.ArchiveZIP,
+	"application/x-zip-compressed": domain.ArchiveZIP,
+	"application/x-tar":            domain.ArchiveTAR,
+	"application/gzip":             domain.ArchiveTARGZ,
+	"application/x-gzip":           domain.ArchiveTARGZ,
+	"application/x-gtar":           domain.ArchiveTARGZ,
+}
+
+// selectFor devuelve el servicio del formato pedido. El orden de prioridad es: el formato
+// indicado en el cuerpo (requested), el parámetro archive de la URL, el header Accept y por último ZIP.
+func (a archiveSelector) selectFor(r *http.Request, requested domain.ArchiveFormat) (domain.ArchiveService, error) {
+	if requested == "" {
+		requested = domain.ArchiveFormat(r.URL.Query().Get("archive"))
+	}
+
+	if requested == "" {
+		for _, mediaType := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if format, ok := archiveMediaTypes[strings.TrimSpace(strings.ToLower(mediaType))]; ok {
+				requested = format
+				break
+			}
+		}
+	}
+
+	if requested == "" {
+		requested = domain.ArchiveZIP
+	}
+
+	archive, ok := a[requested]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", domain.ErrUnsupportedArchive, requested)
+	}
+	return archive, nil
+}
+
+// readPart lee una parte de un formulario multipart, hasta limit bytes
+func readPart(ctx context.Context, part *multipart.Part, limit int64) (data []byte, err error) {
+	_, span := services.StartSpan(ctx, "multipart.read_part",
+		attribute.String("multipart.field", part.FormName()),
+		attribute.String("multipart.filename", part.FileName()),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("multipart.file_bytes", len(data)))
+		services.EndSpan(span, err)
+	}()
+
+	return io.ReadAll(io.LimitReader(part, limit))
+}
+
+// decodeJSONBody decodifica el cuerpo JSON de la petición en value
+func decodeJSONBody(r *http.Request, value interface{}) (err error) {
+	_, span := services.StartSpan(r.Context(), "json.decode")
+	defer func() { services.EndSpan(span, err) }()
+
+	return json.NewDecoder(r.Body).Decode(value)
+}
+
+// compressionParams agrupa los parámetros de compresión recibidos en un formulario
+type compressionParams struct {
+	quality  int
+	format   domain.ImageFormat
+	ifLarger domain.IfLargerPolicy
+}
+
+// defaultCompressionParams devuelve los parámetros de compresión por defecto
+func defaultCompressionParams() compressionParams {
+	return compressionParams{
+		quality: 80,
+		format:  domain.JPEG,
+	}
+}
+
+// setFromPart aplica el valor de una parte multipart si corresponde a un parámetro de compresión.
+// Devuelve false si la parte no es un parámetro.
+func (p *compressionParams) setFromPart(part *multipart.Part) (bool, error) {
+	name := part.FormName()
+	if name != "quality" && name != "format" && name != "if_larger" {
+		return false, nil
+	}
+
+	value, err := io.ReadAll(io.LimitReader(part, 64))
+	if err != nil {
+		return true, err
+	}
+
+	switch name {
+	case "quality":
+		if q, err := strconv.Atoi(string(value)); err == nil {
+			p.quality = q
+		}
+	case "format":
+		if len(value) > 0 {
+			p.format = domain.ImageFormat(value)
+		}
+	case "if_larger":
+		p.ifLarger = domain.IfLargerPolicy(value)
+	}
+	return true, nil
+}
+
+// jobOwner devuelve el dueño de los trabajos creados o consultados por r: el nombre de su
+// API key, o "" si la autenticación está desactivada
+func jobOwner(r *http.Request) string {
+	if key := domain.APIKeyFromContext(r.Context()); key != nil {
+		return key.Name
+	}
+	return ""
+}
+
+// jobErrorStatus traduce un error de un trabajo al código HTTP correspondiente
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrJobNotFound), errors.Is(err, domain.ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrJobNotFinished), errors.Is(err, domain.ErrJobFailed):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrTooManyJobs):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// archiveErrorStatus traduce un error al procesar un ZIP al código HTTP correspondiente
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrArchiveTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, domain.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, domain.ErrInvalidArchive), errors.Is(err, domain.ErrUnsafeArchivePath), errors.Is(err, domain.ErrBatchSizeExceeded):
+		return http.StatusBadRequest
+	default:
+		return compressionErrorStatus(err)
+	}
+}
+
+// compressionErrorStatus traduce un error de compresión al código HTTP correspondiente
+func compressionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrOutputNotSmaller):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrInvalidQuality), errors.Is(err, domain.ErrInvalidPolicy), errors.Is(err, domain.ErrInvalidImageData):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setCompressionResultHeaders indica si el resultado es la imagen recomprimida o la original
+// y, si su formato no es el pedido, cuál es: al devolver la original se descarta también
+// la conversión de formato
+func setCompressionResultHeaders(header http.Header, result *domain.CompressionResult) {
+	kind := "compressed"
+	if result.Original {
+		kind = "original"
+	}
+	header.Set("X-Compression-Result", kind)
+	if result.RequestedFormat != "" {
+		header.Set("X-Format-Fallback", string(result.Format))
+	}
+}
+
+// authenticate exige una API key válida en el header X-API-Key o como token Bearer y la
+// guarda en el contexto de la petición, de donde se leen sus límites. Las respuestas dependen
+// de la clave, así que por defecto no se guardan en ninguna caché (ver setCacheHeaders).
+func authenticate(keys *services.APIKeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "private, no-store")
+			w.Header().Set("Vary", "Authorization, X-API-Key")
+			key, ok := keys.Authenticate(requestAPIKey(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="image-compress"`)
+				http.Error(w, domain.ErrInvalidAPIKey.Error()+" (use el header X-API-Key o Authorization: Bearer)", http.StatusUnauthorized)
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("api_key.name", key.Name))
+			next.ServeHTTP(w, r.WithContext(domain.WithAPIKey(r.Context(), key)))
+		})
+	}
+}
+
+// requireToken exige que la petición envíe token en Authorization: Bearer o X-API-Key. Protege
+// /metrics con una credencial propia del scraper, distinta de las API keys de los clientes.
+func requireToken(token string) func(http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(token))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := sha256.Sum256([]byte(requestAPIKey(r)))
+			if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="image-compress-metrics"`)
+				http.Error(w, "Token de métricas inválido o ausente", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestAPIKey devuelve la API key enviada en X-API-Key o en Authorization: Bearer
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// rateLimitRequests limita las peticiones de cada cliente (su API key o, sin autenticación,
+// su IP) con un token bucket. Informa del estado en los headers X-RateLimit-* y responde
+// 429 con Retry-After cuando el cliente agota el bucket.
+func rateLimitRequests(limiter *services.RateLimiter, global domain.RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := domain.APIKeyFromContext(r.Context())
+			client := requestClient(r, key)
+			r = r.WithContext(domain.WithClient(r.Context(), client))
+
+			limit := key.RateLimitFor(global)
+			status, ok := limiter.Allow(client, limit)
+			if limit.Rate > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+				w.Header().Set("X-RateLimit-Reset", ceilSeconds(status.Reset))
+			}
+			if !ok {
+				w.Header().Set("Retry-After", ceilSeconds(status.RetryAfter))
+				http.Error(w, fmt.Sprintf("%v: máximo %g peticiones por segundo", domain.ErrRateLimited, limit.Rate), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// enforceQuota rechaza con 429 las peticiones de los clientes que agotaron su cuota diaria
+// de imágenes o bytes. El consumo se cuenta al comprimir; para que una petición no supere la
+// cuota, cada handler aparta con reserveQuota las imágenes que va a comprimir y lo apartado
+// se devuelve al terminar la petición.
+func enforceQuota(quotas *services.QuotaTracker, global domain.Quota) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			quota := domain.APIKeyFromContext(r.Context()).QuotaFor(global)
+			if quota.Images <= 0 && quota.Bytes <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			usage := quotas.Usage(domain.ClientFromContext(r.Context()))
+			if quota.Images > 0 {
+				w.Header().Set("X-Quota-Images-Remaining", strconv.FormatInt(max(quota.Images-usage.Images, 0), 10))
+			}
+			if quota.Bytes > 0 {
+				w.Header().Set("X-Quota-Bytes-Remaining", strconv.FormatInt(max(quota.Bytes-usage.Bytes, 0), 10))
+			}
+			if quota.Exceeds(usage) {
+				w.Header().Set("Retry-After", ceilSeconds(time.Until(usage.Reset)))
+				http.Error(w, fmt.Sprintf("%v, se reinicia a las %s", domain.ErrQuotaExceeded, usage.Reset.Format(time.RFC3339)), http.StatusTooManyRequests)
+				return
+			}
+			reservation := quotas.Reserve(domain.ClientFromContext(r.Context()), quota)
+			defer reservation.Release()
+			next.ServeHTTP(w, r.WithContext(services.WithQuotaReservation(r.Context(), reservation)))
+		})
+	}
+}
+
+// reserveQuota aparta de la cuota diaria del cliente images imágenes y bytes bytes de
+// entrada que la petición va a comprimir (ver enforceQuota). Devuelve domain.ErrQuotaExceeded
+// si no caben en lo que le queda.
+func reserveQuota(r *http.Request, images int, bytes int64) error {
+	return services.QuotaReservationFromContext(r.Context()).Add(images, bytes)
+}
+
+// quotaAllows aparta la cuota de la petición con reserveQuota. Si no cabe responde 429 y
+// devuelve false.
+func quotaAllows(w http.ResponseWriter, r *http.Request, images int, bytes int64) bool {
+	if err := reserveQuota(r, images, bytes); err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// batchInputBytes devuelve el tamaño total de las imágenes del lote
+func batchInputBytes(images []domain.ImageData) int64 {
+	var total int64
+	for _, image := range images {
+		total += int64(len(image.Data))
+	}
+	return total
+}
+
+// requestClient identifica al cliente de la petición para los límites: su API key o, si
+// la autenticación está desactivada, la IP de origen (calculada por middleware.RealIP)
+func requestClient(r *http.Request, key *domain.APIKey) string {
+	if key != nil {
+		return "key:" + key.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ceilSeconds formatea d en segundos enteros redondeando hacia arriba, como en Retry-After
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(max(d, 0).Seconds())), 10)
+}
+
+// formatAllowed responde 403 y devuelve false si la API key de la petición no permite
+// pedir imágenes en format
+func formatAllowed(w http.ResponseWriter, r *http.Request, format domain.ImageFormat) bool {
+	if domain.APIKeyFromContext(r.Context()).AllowsFormat(format) {
+		return true
+	}
+	http.Error(w, formatNotAllowedMessage(format), http.StatusForbidden)
+	return false
+}
+
+// formatNotAllowedMessage es el mensaje de error de un formato no permitido por la API key
+func formatNotAllowedMessage(format domain.ImageFormat) string {
+	if format == "" {
+		format = domain.JPEG
+	}
+	return fmt.Sprintf("%v: %s", domain.ErrFormatNotAllowed, format)
+}
+
+// observeRequests registra cada petición en el log y en metrics, con el patrón de su ruta,
+// y la envuelve en un span del que cuelgan los de su procesamiento
+func observeRequests(metrics *services.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ctx, span := services.StartServerSpan(r)
+			r = r.WithContext(ctx)
+
+			// Devolver el ID para que el cliente pueda correlacionar su petición con los logs
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				ww.Header().Set(middleware.RequestIDHeader, id)
+			}
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				// Un panic termina en un 500 de middleware.Recoverer, que está por fuera
+				panicked := recover()
+				if panicked != nil {
+					status = http.StatusInternalServerError
+				}
+
+				// El patrón solo se conoce después de que el router resuelve la ruta
+				route := chi.RouteContext(r.Context()).RoutePattern()
+				if route == "" {
+					route = "not_found"
+				}
+				duration := time.Since(start)
+				metrics.ObserveRequest(route, r.Method, status, duration)
+
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+				if status >= http.StatusInternalServerError {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+				span.End()
+
+				level := slog.LevelInfo
+				if status >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+				slog.Log(r.Context(), level, "Petición",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", status,
+					"bytes", ww.BytesWritten(),
+					"duration_ms", duration.Milliseconds(),
+					"remote_ip", r.RemoteAddr,
+				)
+
+				if panicked != nil {
+					panic(panicked)
+				}
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// requestContextHandler agrega a cada registro el request_id, la ruta y la traza de la
+// petición que viaja en el contexto, si la hay
+type requestContextHandler struct {
+	slog.Handler
+}
+
+// Handle agrega los atributos de la petición y delega en el handler envuelto
+func (h requestContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(requestAttrs(ctx)...)
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs conserva el envoltorio al derivar loggers con atributos
+func (h requestContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup conserva el envoltorio al derivar loggers con grupos
+func (h requestContextHandler) WithGroup(name string) slog.Handler {
+	return requestContextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestAttrs devuelve el request_id, la ruta, el trace_id y la API key de la petición de ctx
+func requestAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	var attrs []slog.Attr
+	if id := middleware.GetReqID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	if key := domain.APIKeyFromContext(ctx); key != nil {
+		attrs = append(attrs, slog.String("api_key", key.Name))
+	}
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			attrs = append(attrs, slog.String("route", route))
+		}
+	}
+	return attrs
+}
+
+// requestLogger devuelve un logger con los atributos de r fijados, para registrar trabajo
+// que sigue después de la petición (lotes en streaming, trabajos asíncronos): chi reutiliza
+// el contexto de ruta cuando la petición termina.
+func requestLogger(r *http.Request) *slog.Logger {
+	attrs := requestAttrs(r.Context())
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	return slog.Default().With(args...)
+}
+
+// logCompressedImage registra una imagen comprimida con sus dimensiones, tamaños y duración
+func logCompressedImage(ctx context.Context, logger *slog.Logger, input string, inputSize int, result *domain.CompressionResult, duration time.Duration) {
+	logger.InfoContext(ctx, "Imagen comprimida",
+		"input", input,
+		"format", result.Format,
+		"width", result.Width,
+		"height", result.Height,
+		"input_bytes", inputSize,
+		"output_bytes", len(result.Data),
+		"original", result.Original,
+		"cache", result.Cache,
+		"duration_ms", duration.Milliseconds(),
+	)
+}
+
+// fatal registra un error de arranque y termina el proceso
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// setCacheHeaders configura los headers de caché HTTP del resultado de r. Si r se autenticó
+// con una API key el resultado es solo de su dueño: Cache-Control pasa a private, y el Vary
+// que pone authenticate evita que el cliente lo reutilice con otra clave.
+func setCacheHeaders(header http.Header, r *http.Request, etag, cacheControl string) {
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if domain.APIKeyFromContext(r.Context()) != nil {
+		cacheControl = services.PrivateCacheControl(cacheControl)
+	}
+	if cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+}
+
+// etagMatches indica si el header If-None-Match de r incluye etag. Según RFC 9110 la
+// comparación es débil: se ignora el prefijo W/. "*" solo coincide en GET y HEAD: en un
+// POST el resultado aún no existe, así que no puede estar en la caché del cliente.
+func etagMatches(r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	anyAllowed := r.Method == http.MethodGet || r.Method == http.MethodHead
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if (candidate == "*" && anyAllowed) || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON responde con el código de estado y el valor codificado en JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// newStorage crea el almacenamiento de resultados indicado por STORAGE_BACKEND, leyendo
+// las variables de entorno propias de cada backend
+func newStorage(backend string) (domain.Storage, error) {
+	switch backend {
+	case "memory":
+		return services.NewMemoryStorage(), nil
+	case "fs":
+		return services.NewFileStorage(getEnv("STORAGE_PATH", "./data"))
+	case "s3":
+		useSSL, err := strconv.ParseBool(getEnv("S3_USE_SSL", "true"))
+		if err != nil {
+			return nil, fmt.Errorf("error parseando S3_USE_SSL: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return services.NewS3Storage(ctx, services.S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "s3.amazonaws.com"),
+			Region:    getEnv("S3_REGION", ""),
+			Bucket:    getEnv("S3_BUCKET", ""),
+			AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("S3_SECRET_KEY", ""),
+			UseSSL:    useSSL,
+		})
+	default:
+		return nil, fmt.Errorf("backend desconocido (use memory, fs o s3)")
+	}
+}
+
 // getEnv obtiene una variable de entorno o devuelve un valor por defecto
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
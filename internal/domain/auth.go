@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"slices"
+)
+
+// APIKey es una clave de acceso a la API con sus propios límites. Los límites en cero usan
+// la configuración global (MAX_IMAGE_SIZE, MAX_BATCH_SIZE, RATE_LIMIT...) y AllowedFormats
+// vacío permite todos los formatos.
+type APIKey struct {
+	Name           string        `json:"name"`
+	Key            string        `json:"key"`
+	MaxImageSize   int64         `json:"max_image_size,omitempty"`
+	MaxBatchSize   int           `json:"max_batch_size,omitempty"`
+	AllowedFormats []ImageFormat `json:"allowed_formats,omitempty"`
+	RateLimit      float64       `json:"rate_limit,omitempty"`       // Peticiones por segundo
+	RateLimitBurst int           `json:"rate_limit_burst,omitempty"` // Ráfaga máxima de peticiones
+	DailyImages    int64         `json:"daily_images,omitempty"`     // Imágenes comprimidas por día
+	DailyBytes     int64         `json:"daily_bytes,omitempty"`      // Bytes de entrada por día
+	Admin          bool          `json:"admin,omitempty"`            // Ve en /stats el uso de todas las claves
+}
+
+// APIKeysConfig es el contenido del archivo de claves (API_KEYS_FILE)
+type APIKeysConfig struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// ImageSizeLimit devuelve el tamaño máximo de imagen de la clave o global si no lo define.
+// Acepta una clave nil (autenticación desactivada).
+func (k *APIKey) ImageSizeLimit(global int64) int64 {
+	if k == nil || k.MaxImageSize <= 0 {
+		return global
+	}
+	return k.MaxImageSize
+}
+
+// BatchSizeLimit devuelve el tamaño máximo de lote de la clave o global si no lo define.
+// Acepta una clave nil (autenticación desactivada).
+func (k *APIKey) BatchSizeLimit(global int) int {
+	if k == nil || k.MaxBatchSize <= 0 {
+		return global
+	}
+	return k.MaxBatchSize
+}
+
+// RateLimitFor devuelve el token bucket de la clave, tomando de global lo que no define.
+// Acepta una clave nil.
+func (k *APIKey) RateLimitFor(global RateLimit) RateLimit {
+	if k == nil {
+		return global
+	}
+	if k.RateLimit > 0 {
+		global.Rate = k.RateLimit
+	}
+	if k.RateLimitBurst > 0 {
+		global.Burst = k.RateLimitBurst
+	}
+	return global
+}
+
+// QuotaFor devuelve la cuota diaria de la clave, tomando de global lo que no define.
+// Acepta una clave nil.
+func (k *APIKey) QuotaFor(global Quota) Quota {
+	if k == nil {
+		return global
+	}
+	if k.DailyImages > 0 {
+		global.Images = k.DailyImages
+	}
+	if k.DailyBytes > 0 {
+		global.Bytes = k.DailyBytes
+	}
+	return global
+}
+
+// AllowsFormat indica si la clave puede pedir imágenes de salida en format. Un formato
+// vacío equivale a JPEG, el formato por defecto. Acepta una clave nil.
+func (k *APIKey) AllowsFormat(format ImageFormat) bool {
+	if k == nil || len(k.AllowedFormats) == 0 {
+		return true
+	}
+	if format == "" {
+		format = JPEG
+	}
+	return slices.Contains(k.AllowedFormats, format)
+}
+
+// apiKeyContextKey es la clave del contexto bajo la que viaja la API key de la petición
+type apiKeyContextKey struct{}
+
+// WithAPIKey devuelve una copia de ctx con la API key que autenticó la petición
+func WithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	if key == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// APIKeyFromContext devuelve la API key de la petición, o nil si la autenticación está desactivada
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
@@ -11,4 +11,71 @@ var (
 	ErrUnsupportedFormat  = errors.New("formato de imagen no soportado")
 	ErrBatchSizeExceeded  = errors.New("tamaño del lote excedido")
 	ErrInvalidImageData   = errors.New("datos de imagen inválidos")
+	ErrInvalidPolicy      = errors.New("política if_larger inválida")
+	ErrOutputNotSmaller   = errors.New("la imagen comprimida no es más pequeña que la original")
+	ErrInvalidArchive     = errors.New("archivo ZIP inválido")
+	ErrArchiveTooLarge    = errors.New("el archivo ZIP excede los límites de descompresión")
+	ErrUnsafeArchivePath  = errors.New("ruta insegura en el archivo ZIP")
+	ErrUnsupportedArchive = errors.New("formato de archivo no soportado")
+	ErrInvalidErrorPolicy = errors.New("política on_error inválida")
+	ErrJobNotFound        = errors.New("trabajo no encontrado")
+	ErrJobNotFinished     = errors.New("el trabajo aún no ha terminado")
+	ErrJobFailed          = errors.New("el trabajo terminó con error")
+	ErrTooManyJobs        = errors.New("demasiados trabajos en curso")
+	ErrInvalidCallbackURL = errors.New("callback_url inválida")
+	ErrWebhookFailed      = errors.New("no se pudo entregar la notificación webhook")
+	ErrWebhookDisabled    = errors.New("callback_url no disponible: el servidor no tiene WEBHOOK_SECRET para firmar las notificaciones")
+	ErrObjectNotFound     = errors.New("objeto no encontrado en el almacenamiento")
+	ErrInvalidStorageKey  = errors.New("clave de almacenamiento inválida")
+	ErrInvalidAPIKey      = errors.New("API key inválida o ausente")
+	ErrFormatNotAllowed   = errors.New("formato de salida no permitido para esta API key")
+	ErrRateLimited        = errors.New("demasiadas peticiones")
+	ErrQuotaExceeded      = errors.New("cuota diaria agotada")
+	ErrUnknownPreset      = errors.New("preset desconocido")
 )
+
+// errorCodes asocia cada error del dominio con un código estable para clientes y manifiestos
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidImageFormat, "invalid_image_format"},
+	{ErrImageTooLarge, "image_too_large"},
+	{ErrInvalidQuality, "invalid_quality"},
+	{ErrEmptyImageData, "empty_image_data"},
+	{ErrUnsupportedFormat, "unsupported_format"},
+	{ErrBatchSizeExceeded, "batch_size_exceeded"},
+	{ErrInvalidImageData, "invalid_image_data"},
+	{ErrInvalidPolicy, "invalid_policy"},
+	{ErrOutputNotSmaller, "output_not_smaller"},
+	{ErrInvalidArchive, "invalid_archive"},
+	{ErrArchiveTooLarge, "archive_too_large"},
+	{ErrUnsafeArchivePath, "unsafe_archive_path"},
+	{ErrUnsupportedArchive, "unsupported_archive"},
+	{ErrInvalidErrorPolicy, "invalid_error_policy"},
+	{ErrJobNotFound, "job_not_found"},
+	{ErrJobNotFinished, "job_not_finished"},
+	{ErrJobFailed, "job_failed"},
+	{ErrTooManyJobs, "too_many_jobs"},
+	{ErrInvalidCallbackURL, "invalid_callback_url"},
+	{ErrWebhookFailed, "webhook_failed"},
+	{ErrWebhookDisabled, "webhook_disabled"},
+	{ErrObjectNotFound, "object_not_found"},
+	{ErrInvalidStorageKey, "invalid_storage_key"},
+	{ErrInvalidAPIKey, "invalid_api_key"},
+	{ErrFormatNotAllowed, "format_not_allowed"},
+	{ErrRateLimited, "rate_limited"},
+	{ErrQuotaExceeded, "quota_exceeded"},
+	{ErrUnknownPreset, "unknown_preset"},
+}
+
+// ErrorCode devuelve el código estable del error del dominio contenido en err,
+// o "internal" si no corresponde a ninguno
+func ErrorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return "internal"
+}
@@ -1,5 +1,12 @@
 package domain
 
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+)
+
 // Este archivo define las estructuras de datos y interfaces del dominio
 
 // ImageFormat representa los formatos de imagen soportados
@@ -11,17 +18,55 @@ const (
 	WEBP ImageFormat = "webp"
 )
 
+// OutputFilename ajusta la extensión del archivo al formato de la imagen resultante
+func OutputFilename(filename string, format ImageFormat) string {
+	ext := path.Ext(filename)
+	switch strings.ToLower(ext) {
+	case "." + string(format):
+		return filename
+	case ".jpg":
+		if format == JPEG {
+			return filename
+		}
+	}
+	return strings.TrimSuffix(filename, ext) + "." + string(format)
+}
+
+// IfLargerPolicy define qué hacer cuando la imagen recomprimida no es más pequeña que la
+// original. La política vacía equivale a IfLargerOriginal.
+type IfLargerPolicy string
+
+const (
+	IfLargerKeep     IfLargerPolicy = "keep"     // Devolver siempre la imagen recomprimida
+	IfLargerOriginal IfLargerPolicy = "original" // Devolver los bytes originales sin modificar (por defecto)
+	IfLargerError    IfLargerPolicy = "error"    // Devolver ErrOutputNotSmaller
+)
+
+// ErrorPolicy define qué hacer cuando falla una imagen de un lote
+type ErrorPolicy string
+
+const (
+	OnErrorFail ErrorPolicy = "fail" // Abortar el lote completo
+	OnErrorSkip ErrorPolicy = "skip" // Registrar el error en el manifiesto y continuar
+)
+
 // CompressionRequest representa una solicitud de compresión
 type CompressionRequest struct {
-	Quality int         `json:"quality" validate:"min=1,max=100"`
-	Format  ImageFormat `json:"format,omitempty"`
+	Quality  int            `json:"quality" validate:"min=1,max=100"`
+	Format   ImageFormat    `json:"format,omitempty"`
+	IfLarger IfLargerPolicy `json:"if_larger,omitempty"`
 }
 
 // BatchCompressionRequest representa una solicitud de compresión en lote
 type BatchCompressionRequest struct {
-	Images  []ImageData `json:"images" validate:"required,min=1,max=10"`
-	Quality int         `json:"quality" validate:"min=1,max=100"`
-	Format  ImageFormat `json:"format,omitempty"`
+	Images   []ImageData    `json:"images" validate:"required,min=1,max=10"`
+	Quality  int            `json:"quality" validate:"min=1,max=100"`
+	Format   ImageFormat    `json:"format,omitempty"`
+	IfLarger IfLargerPolicy `json:"if_larger,omitempty"`
+	Archive  ArchiveFormat  `json:"archive,omitempty"`
+	// ManifestCSV incluye manifest.csv además de manifest.json
+	ManifestCSV bool        `json:"manifest_csv,omitempty"`
+	OnError     ErrorPolicy `json:"on_error,omitempty"`
 }
 
 // ImageData representa los datos de una imagen
@@ -32,9 +77,53 @@ type ImageData struct {
 
 // CompressionResult representa el resultado de una compresión
 type CompressionResult struct {
-	Filename string `json:"filename"`
-	Data     []byte `json:"data"`
-	Size     int64  `json:"size"`
+	Filename string      `json:"filename"`
+	Data     []byte      `json:"data"`
+	Size     int64       `json:"size"`
+	Format   ImageFormat `json:"format"`   // Formato real de Data
+	Original bool        `json:"original"` // true si Data son los bytes originales (política IfLargerOriginal)
+	Width    int         `json:"width"`
+	Height   int         `json:"height"`
+	Warnings []string    `json:"warnings,omitempty"`
+	Cache    CacheStatus `json:"-"` // Vacío si el procesador no usa caché
+
+	// RequestedFormat es el formato pedido cuando Format es otro: no está soportado o se
+	// devolvió la original en su formato (IfLargerOriginal). Vacío si coinciden.
+	RequestedFormat ImageFormat `json:"requested_format,omitempty"`
+}
+
+// CacheStatus indica si un resultado se obtuvo de la caché (header X-Cache)
+type CacheStatus string
+
+const (
+	CacheHit  CacheStatus = "HIT"
+	CacheMiss CacheStatus = "MISS"
+)
+
+// ManifestEntry describe el resultado de una imagen dentro del manifiesto de un lote
+type ManifestEntry struct {
+	Input      string      `json:"input"`
+	Output     string      `json:"output"`
+	InputSize  int64       `json:"input_size"`
+	OutputSize int64       `json:"output_size"`
+	Ratio      float64     `json:"ratio"` // output_size / input_size
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Format     ImageFormat `json:"format"`
+	// Formato pedido si no coincide con format, ver CompressionResult.RequestedFormat
+	RequestedFormat ImageFormat `json:"requested_format,omitempty"`
+	Quality         int         `json:"quality"`
+	Original        bool        `json:"original"`
+	Warnings        []string    `json:"warnings,omitempty"`
+	Error           string      `json:"error,omitempty"`      // Mensaje si la imagen falló (on_error=skip)
+	ErrorCode       string      `json:"error_code,omitempty"` // Código estable del error, ver ErrorCode
+}
+
+// BatchManifest representa el manifest.json incluido en el archivo de un lote
+type BatchManifest struct {
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Images    []ManifestEntry `json:"images"`
 }
 
 // BatchCompressionResult representa el resultado de una compresión en lote
@@ -43,14 +132,63 @@ type BatchCompressionResult struct {
 	Size    int64  `json:"size"`
 }
 
-// ImageProcessor define la interfaz para el procesamiento de imágenes
+// ImageProcessor define la interfaz para el procesamiento de imágenes. ctx lleva el span
+// de la petición, del que cuelgan los spans de cada paso.
 type ImageProcessor interface {
-	CompressImage(imageData []byte, quality int, format ImageFormat) ([]byte, error)
-	ValidateImage(imageData []byte) error
-	GetImageInfo(imageData []byte) (width, height int, format ImageFormat, err error)
+	CompressImage(ctx context.Context, imageData []byte, quality int, format ImageFormat, ifLarger IfLargerPolicy) (*CompressionResult, error)
+	ValidateImage(ctx context.Context, imageData []byte) error
+	GetImageInfo(ctx context.Context, imageData []byte) (width, height int, format ImageFormat, err error)
+}
+
+// ZipLimits define los límites de seguridad al leer un ZIP enviado por el cliente
+type ZipLimits struct {
+	MaxEntries   int   // Número máximo de entradas
+	MaxEntrySize int64 // Tamaño máximo descomprimido por entrada
+	MaxTotalSize int64 // Tamaño máximo descomprimido de todo el archivo
+	MaxRatio     int64 // Relación máxima descomprimido/comprimido por entrada
+}
+
+// ArchiveFormat representa los formatos de archivo soportados para los resultados de un lote
+type ArchiveFormat string
+
+const (
+	ArchiveZIP   ArchiveFormat = "zip"
+	ArchiveTAR   ArchiveFormat = "tar"
+	ArchiveTARGZ ArchiveFormat = "tar.gz"
+)
+
+// ZipMethod define cómo se comprimen las entradas de los ZIP generados
+type ZipMethod string
+
+const (
+	ZipMethodAuto    ZipMethod = "auto"    // Store para datos ya comprimidos (imágenes, ZIP, gzip), deflate para el resto
+	ZipMethodStore   ZipMethod = "store"   // Guardar todas las entradas sin comprimir
+	ZipMethodDeflate ZipMethod = "deflate" // Comprimir todas las entradas con deflate
+)
+
+// ArchiveWriter define la interfaz para escribir entradas de un archivo de forma incremental.
+// AddFile y AddPath devuelven el nombre final de la entrada tras sanitizarlo; si coincide
+// con una entrada anterior se desambigua como "foto (1).jpg".
+type ArchiveWriter interface {
+	AddFile(filename string, data []byte) (string, error)
+	AddPath(path string, data []byte) (string, error)
+	AddManifest(manifest *BatchManifest, withCSV bool) error
+	Close() error
+}
+
+// ArchiveService define la interfaz para la creación de archivos (ZIP, TAR, TAR.GZ)
+type ArchiveService interface {
+	Format() ArchiveFormat
+	ContentType() string
+	CreateArchive(files []ImageData) ([]byte, error)
+	NewWriter(w io.Writer) ArchiveWriter
+	NewStreamWriter(w io.Writer) ArchiveWriter
 }
 
-// ZipService define la interfaz para la creación de archivos ZIP
+// ZipService define la interfaz para la creación y lectura de archivos ZIP
 type ZipService interface {
-	CreateZip(files map[string][]byte) ([]byte, error)
+	ArchiveService
+	// ReadZip llama a fn con cada archivo del ZIP: su posición en el ZIP (empezando en 1,
+	// contando todas las entradas), su ruta sanitizada y su contenido
+	ReadZip(data []byte, limits ZipLimits, fn func(index int, path string, data []byte) error) error
 }
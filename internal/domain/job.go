@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// JobStatus representa el estado de un trabajo asíncrono
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"    // Creado, aún no comenzó
+	JobRunning   JobStatus = "running"   // Procesando imágenes
+	JobCompleted JobStatus = "completed" // Terminado, el resultado se puede descargar
+	JobFailed    JobStatus = "failed"    // Terminado con error, no hay resultado
+)
+
+// JobRequest representa la solicitud de un trabajo asíncrono: un lote y, opcionalmente,
+// la URL que recibe una notificación cuando termina y el preset de sus opciones
+type JobRequest struct {
+	BatchCompressionRequest
+	CallbackURL string `json:"callback_url,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+}
+
+// Job representa el estado de un lote procesado en segundo plano
+type Job struct {
+	ID          string     `json:"id"`
+	Status      JobStatus  `json:"status"`
+	Total       int        `json:"total"`     // Imágenes del lote
+	Processed   int        `json:"processed"` // Imágenes procesadas (correctas o fallidas)
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Progress    int        `json:"progress"` // Porcentaje procesado (0-100)
+	Error       string     `json:"error,omitempty"`
+	ResultURL   string     `json:"result_url,omitempty"`
+	CallbackURL string     `json:"callback_url,omitempty"` // Recibe una notificación webhook al terminar
+	Preset      string     `json:"preset,omitempty"`       // Define el Cache-Control del resultado
+	Owner       string     `json:"-"`                      // API key que lo creó; solo ella puede consultarlo
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Eventos de las notificaciones webhook
+const (
+	JobEventCompleted = "job.completed"
+	JobEventFailed    = "job.failed"
+)
+
+// Tipos de los eventos de progreso de una imagen
+const (
+	JobEventImageStarted   = "image.started"
+	JobEventImageCompleted = "image.completed"
+	JobEventImageFailed    = "image.failed"
+)
+
+// JobEvent es un evento de progreso de un trabajo, enviado por GET /jobs/{id}/events.
+// Los trabajos terminan con un evento job.completed o job.failed.
+type JobEvent struct {
+	Type       string  `json:"type"`
+	Index      int     `json:"index,omitempty"` // Posición de la imagen en el lote, empezando en 1
+	Input      string  `json:"input,omitempty"`
+	Output     string  `json:"output,omitempty"`
+	InputSize  int64   `json:"input_size,omitempty"`
+	OutputSize int64   `json:"output_size,omitempty"`
+	SavedBytes int64   `json:"saved_bytes,omitempty"` // input_size - output_size
+	Ratio      float64 `json:"ratio,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	ErrorCode  string  `json:"error_code,omitempty"`
+	Processed  int     `json:"processed"`
+	Total      int     `json:"total"`
+	Job        *Job    `json:"job,omitempty"` // Estado final, solo en job.completed y job.failed
+}
+
+// JobNotification es el cuerpo de la notificación webhook de un trabajo terminado
+type JobNotification struct {
+	Event  string          `json:"event"`
+	Job    Job             `json:"job"`
+	Images []ManifestEntry `json:"images,omitempty"` // Estadísticas por imagen
+}
+
+// JobNotifier envía la notificación de un trabajo terminado a la URL indicada
+type JobNotifier interface {
+	// ValidateURL comprueba, al crear el trabajo, que se pueda notificar a url
+	ValidateURL(ctx context.Context, url string) error
+	Notify(ctx context.Context, url string, notification *JobNotification) error
+}
+
+// JobResult es el archivo generado por un trabajo terminado. Data solo se usa hasta
+// guardarlo en el almacenamiento, donde queda con la clave Key.
+type JobResult struct {
+	Data        []byte
+	Key         string
+	Size        int64
+	ETag        string
+	ContentType string
+	Filename    string
+	Originals   []string // Posiciones de las imágenes devueltas sin modificar
+	Manifest    *BatchManifest
+}
@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+// MetricsRecorder recibe las mediciones del procesamiento de imágenes y lotes
+type MetricsRecorder interface {
+	// ObserveDecode registra cuánto tardó en decodificarse una imagen del formato dado
+	ObserveDecode(format ImageFormat, duration time.Duration)
+	// ObserveEncode registra cuánto tardó en codificarse una imagen en el formato dado
+	ObserveEncode(format ImageFormat, duration time.Duration)
+	// ObserveImage registra una imagen comprimida con sus formatos y tamaños
+	ObserveImage(input, output ImageFormat, inputSize, outputSize int64)
+	// ObserveError registra el error por el que se rechazó una imagen
+	ObserveError(err error)
+	// ObserveBatch registra el número de imágenes de un lote, también si se detuvo antes de terminar
+	ObserveBatch(size int)
+	// ObserveCache registra una consulta a la caché de resultados y, si acertó, el nivel
+	// (memory o disk) que tenía el resultado
+	ObserveCache(status CacheStatus, tier string)
+}
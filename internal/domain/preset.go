@@ -0,0 +1,17 @@
+package domain
+
+// Preset es un conjunto de opciones con nombre que el cliente elige con el parámetro preset.
+// Las opciones que envía el cliente tienen prioridad sobre las del preset.
+type Preset struct {
+	Name    string      `json:"name"`
+	Quality int         `json:"quality,omitempty"`
+	Format  ImageFormat `json:"format,omitempty"`
+	// CacheControl es el header Cache-Control de los resultados del preset; vacío usa el
+	// global (CACHE_CONTROL)
+	CacheControl string `json:"cache_control,omitempty"`
+}
+
+// PresetsConfig es el contenido del archivo de presets (PRESETS_FILE)
+type PresetsConfig struct {
+	Presets []Preset `json:"presets"`
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimit es el token bucket de un cliente: Rate peticiones por segundo sostenidas con
+// ráfagas de hasta Burst peticiones. Rate en cero desactiva el límite.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStatus es el estado del bucket de un cliente tras una petición
+type RateLimitStatus struct {
+	Limit      int           // Tamaño del bucket (X-RateLimit-Limit)
+	Remaining  int           // Peticiones disponibles ahora (X-RateLimit-Remaining)
+	Reset      time.Duration // Tiempo hasta que el bucket vuelve a estar lleno (X-RateLimit-Reset)
+	RetryAfter time.Duration // Espera hasta la siguiente petición permitida, si se rechazó
+}
+
+// Quota es el consumo diario permitido a un cliente: imágenes comprimidas y bytes de entrada.
+// Cero significa sin límite.
+type Quota struct {
+	Images int64
+	Bytes  int64
+}
+
+// QuotaUsage es el consumo de un cliente en el día en curso (UTC)
+type QuotaUsage struct {
+	Images int64
+	Bytes  int64
+	Reset  time.Time // Inicio del día siguiente, cuando se reinicia el consumo
+}
+
+// Exceeds indica si usage agotó alguna de las cuotas de q
+func (q Quota) Exceeds(usage QuotaUsage) bool {
+	return (q.Images > 0 && usage.Images >= q.Images) || (q.Bytes > 0 && usage.Bytes >= q.Bytes)
+}
+
+// clientContextKey es la clave del contexto bajo la que viaja el cliente de la petición
+type clientContextKey struct{}
+
+// WithClient devuelve una copia de ctx con el cliente al que se atribuyen los límites de la
+// petición: la API key o la IP de origen
+func WithClient(ctx context.Context, client string) context.Context {
+	if client == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// ClientFromContext devuelve el cliente de la petición, o "" si no se identificó
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientContextKey{}).(string)
+	return client
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// UsageStats resume el uso de la API desde que arrancó el servidor
+type UsageStats struct {
+	Since         time.Time `json:"since"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Key           string    `json:"key,omitempty"` // API key a la que se limitan los contadores
+	UsageSummary
+	Keys map[string]*UsageSummary `json:"keys,omitempty"` // Por nombre de API key, solo para claves admin
+}
+
+// UsageSummary son los contadores de uso de toda la API o de una API key
+type UsageSummary struct {
+	ImagesProcessed int64                        `json:"images_processed"` // Incluye los resultados servidos desde la caché
+	ImagesFailed    int64                        `json:"images_failed"`
+	InputBytes      int64                        `json:"input_bytes"`
+	OutputBytes     int64                        `json:"output_bytes"`
+	BytesSaved      int64                        `json:"bytes_saved"`   // Negativo si los resultados ocuparon más que las entradas
+	AverageRatio    float64                      `json:"average_ratio"` // Media de tamaño de salida / tamaño de entrada por imagen
+	Formats         map[ImageFormat]*FormatStats `json:"formats"`       // Por formato de salida
+	TopErrors       []ErrorCount                 `json:"top_errors"`
+}
+
+// FormatStats resume las imágenes comprimidas a un formato de salida
+type FormatStats struct {
+	Images       int64   `json:"images"`
+	InputBytes   int64   `json:"input_bytes"`
+	OutputBytes  int64   `json:"output_bytes"`
+	BytesSaved   int64   `json:"bytes_saved"`
+	AverageRatio float64 `json:"average_ratio"`
+}
+
+// ErrorCount es el número de imágenes que fallaron con un código de error
+type ErrorCount struct {
+	Code  string `json:"code"`
+	Count int64  `json:"count"`
+}
+
+// StatsRecorder acumula el resultado de cada imagen procesada para las estadísticas de uso.
+// ctx identifica la API key de la petición, si la hay.
+type StatsRecorder interface {
+	// RecordImage registra una imagen comprimida de inputSize bytes
+	RecordImage(ctx context.Context, inputSize int, result *CompressionResult)
+	// RecordError registra una imagen que no se pudo comprimir
+	RecordError(ctx context.Context, err error)
+}
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"context"
+	"io"
+)
+
+// StoredObject describe un objeto guardado en el almacenamiento de resultados
+type StoredObject struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ETag        string `json:"etag,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Storage define la interfaz del almacenamiento de resultados (memoria, sistema de archivos o S3).
+// Las claves usan "/" como separador, por ejemplo "jobs/<id>/resultado.zip".
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) (*StoredObject, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *StoredObject, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// UploadedImage describe una imagen comprimida guardada en el almacenamiento en lugar de
+// devolverse en la respuesta (upload=true)
+type UploadedImage struct {
+	ManifestEntry
+	Key  string `json:"key,omitempty"`
+	ETag string `json:"etag,omitempty"`
+	URL  string `json:"url,omitempty"` // Vacía si no se configuró UPLOAD_PUBLIC_URL
+}
+
+// UploadResult es la respuesta de una compresión en lote con upload=true
+type UploadResult struct {
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Images    []UploadedImage `json:"images"`
+}
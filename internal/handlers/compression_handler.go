@@ -69,27 +69,29 @@ func (h *CompressionHandler) CompressImage(w http.ResponseWriter, r *http.Reques
 		format = domain.ImageFormat(formatStr)
 	}
 
+	ifLarger := domain.IfLargerPolicy(r.FormValue("if_larger"))
+
 	// Validar imagen
-	if err := h.imageProcessor.ValidateImage(imageData); err != nil {
+	if err := h.imageProcessor.ValidateImage(r.Context(), imageData); err != nil {
 		http.Error(w, fmt.Sprintf("Imagen inválida: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	// Comprimir imagen
-	compressedData, err := h.imageProcessor.CompressImage(imageData, quality, format)
+	result, err := h.imageProcessor.CompressImage(r.Context(), imageData, quality, format, ifLarger)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error comprimiendo imagen: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Configurar headers para descarga
-	filename := fmt.Sprintf("compressed_%d.%s", time.Now().Unix(), format)
+	filename := fmt.Sprintf("compressed_%d.%s", time.Now().Unix(), result.Format)
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	w.Header().Set("Content-Length", strconv.Itoa(len(compressedData)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
 
 	// Escribir datos comprimidos
-	if _, err := w.Write(compressedData); err != nil {
+	if _, err := w.Write(result.Data); err != nil {
 		http.Error(w, "Error escribiendo respuesta", http.StatusInternalServerError)
 		return
 	}
@@ -116,31 +118,34 @@ func (h *CompressionHandler) CompressBatch(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Procesar cada imagen
-	files := make(map[string][]byte)
+	files := make([]domain.ImageData, 0, len(req.Images))
 	for i, imgData := range req.Images {
 		// Validar imagen
-		if err := h.imageProcessor.ValidateImage(imgData.Data); err != nil {
+		if err := h.imageProcessor.ValidateImage(r.Context(), imgData.Data); err != nil {
 			http.Error(w, fmt.Sprintf("Imagen %d inválida: %v", i+1, err), http.StatusBadRequest)
 			return
 		}
 
 		// Comprimir imagen
-		compressedData, err := h.imageProcessor.CompressImage(imgData.Data, req.Quality, req.Format)
+		result, err := h.imageProcessor.CompressImage(r.Context(), imgData.Data, req.Quality, req.Format, req.IfLarger)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error comprimiendo imagen %d: %v", i+1, err), http.StatusInternalServerError)
 			return
 		}
 
-		// Agregar al mapa de archivos
+		// Agregar a la lista de archivos; los nombres repetidos se desambiguan al crear el ZIP
 		filename := imgData.Filename
 		if filename == "" {
 			filename = fmt.Sprintf("image_%d.%s", i+1, req.Format)
 		}
-		files[filename] = compressedData
+		files = append(files, domain.ImageData{
+			Filename: domain.OutputFilename(filename, result.Format),
+			Data:     result.Data,
+		})
 	}
 
 	// Crear archivo ZIP
-	zipData, err := h.zipService.CreateZip(files)
+	zipData, err := h.zipService.CreateArchive(files)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error creando ZIP: %v", err), http.StatusInternalServerError)
 		return
@@ -183,13 +188,13 @@ func (h *CompressionHandler) GetImageInfo(w http.ResponseWriter, r *http.Request
 	}
 
 	// Validar imagen
-	if err := h.imageProcessor.ValidateImage(imageData); err != nil {
+	if err := h.imageProcessor.ValidateImage(r.Context(), imageData); err != nil {
 		http.Error(w, fmt.Sprintf("Imagen inválida: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	// Obtener información
-	width, height, format, err := h.imageProcessor.GetImageInfo(imageData)
+	width, height, format, err := h.imageProcessor.GetImageInfo(r.Context(), imageData)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error obteniendo información: %v", err), http.StatusInternalServerError)
 		return
@@ -37,19 +37,58 @@ func (h *HealthHandler) GetAPIInfo(w http.ResponseWriter, r *http.Request) {
 			"POST /compress": map[string]interface{}{
 				"description": "Comprime una sola imagen",
 				"parameters": map[string]interface{}{
-					"image":   "Archivo de imagen (multipart/form-data)",
-					"quality": "Calidad de compresión (1-100, opcional, default: 80)",
-					"format":  "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"image":     "Archivo de imagen (multipart/form-data)",
+					"quality":   "Calidad de compresión (1-100, opcional, default: 80)",
+					"format":    "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"if_larger": "Si el resultado no es más pequeño: keep, original o error (opcional, default: original)",
+					"upload":    "true para guardar la imagen en el almacenamiento y responder con su clave (opcional)",
 				},
 			},
 			"POST /compress/batch": map[string]interface{}{
 				"description": "Comprime múltiples imágenes y las devuelve en un ZIP",
 				"parameters": map[string]interface{}{
-					"images":  "Array de objetos con filename y data (JSON)",
-					"quality": "Calidad de compresión (1-100)",
-					"format":  "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"images":    "Array de objetos con filename y data (JSON)",
+					"archive":   "Formato del archivo de salida (zip, tar, tar.gz, opcional, default: zip)",
+					"on_error":  "Si falla una imagen: fail aborta el lote, skip la registra en el manifiesto (opcional, default: fail)",
+					"upload":    "?upload=true guarda las imágenes en el almacenamiento y responde con sus claves en JSON (opcional)",
+					"quality":   "Calidad de compresión (1-100)",
+					"format":    "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"if_larger": "Si el resultado no es más pequeño: keep, original o error (opcional, default: original)",
 				},
 			},
+			"POST /compress/batch/multipart": map[string]interface{}{
+				"description": "Comprime múltiples imágenes enviadas como multipart y las devuelve en un ZIP",
+				"parameters": map[string]interface{}{
+					"images[]":  "Archivos de imagen (multipart/form-data, uno por campo)",
+					"quality":   "Calidad de compresión (1-100, opcional, default: 80)",
+					"format":    "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"if_larger": "Si el resultado no es más pequeño: keep, original o error (opcional, default: original)",
+				},
+			},
+			"POST /compress/archive": map[string]interface{}{
+				"description": "Comprime las imágenes de un ZIP conservando carpetas y devuelve un nuevo ZIP",
+				"parameters": map[string]interface{}{
+					"archive":   "Archivo ZIP (multipart/form-data)",
+					"quality":   "Calidad de compresión (1-100, opcional, default: 80)",
+					"format":    "Formato de salida (jpeg, png, webp, opcional, default: jpeg)",
+					"if_larger": "Si el resultado no es más pequeño: keep, original o error (opcional, default: original)",
+				},
+			},
+			"POST /jobs": map[string]interface{}{
+				"description": "Crea un trabajo asíncrono con el mismo cuerpo que /compress/batch",
+				"parameters": map[string]interface{}{
+					"callback_url": "URL que recibe un POST firmado cuando el trabajo termina (opcional)",
+				},
+			},
+			"GET /jobs/{id}": map[string]interface{}{
+				"description": "Estado y progreso de un trabajo",
+			},
+			"GET /jobs/{id}/events": map[string]interface{}{
+				"description": "Progreso de un trabajo como Server-Sent Events",
+			},
+			"GET /jobs/{id}/result": map[string]interface{}{
+				"description": "Descarga el archivo generado por un trabajo terminado",
+			},
 			"POST /compress/info": map[string]interface{}{
 				"description": "Obtiene información de una imagen",
 				"parameters": map[string]interface{}{
@@ -59,6 +98,12 @@ func (h *HealthHandler) GetAPIInfo(w http.ResponseWriter, r *http.Request) {
 			"GET /health": map[string]interface{}{
 				"description": "Health check de la API",
 			},
+			"GET /metrics": map[string]interface{}{
+				"description": "Métricas en formato Prometheus",
+			},
+			"GET /stats": map[string]interface{}{
+				"description": "Estadísticas de uso desde el arranque: imágenes procesadas, bytes ahorrados y errores",
+			},
 			"GET /": map[string]interface{}{
 				"description": "Información de la API",
 			},
@@ -66,6 +111,8 @@ func (h *HealthHandler) GetAPIInfo(w http.ResponseWriter, r *http.Request) {
 		"supported_formats": []string{"jpeg", "png", "webp"},
 		"max_image_size":    "32MB",
 		"max_batch_size":    10,
+		"authentication":    "API key en X-API-Key o Authorization: Bearer, si el servidor tiene claves configuradas",
+		"rate_limit":        "Desactivado por defecto; con RATE_LIMIT, peticiones por segundo por cliente (429 con Retry-After al superarlo)",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// APIKeyStore autentica las peticiones con las claves configuradas. Las claves se indexan
+// por su hash SHA-256 para no compararlas byte a byte con la que envía el cliente.
+type APIKeyStore struct {
+	keys map[[sha256.Size]byte]*domain.APIKey
+}
+
+// NewAPIKeyStore crea el almacén con keys. Cada clave debe tener nombre y valor únicos y
+// solo formatos de salida soportados.
+func NewAPIKeyStore(keys []domain.APIKey) (*APIKeyStore, error) {
+	store := &APIKeyStore{keys: make(map[[sha256.Size]byte]*domain.APIKey, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := keys[i]
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("la API key %d no tiene nombre o valor", i+1)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key duplicada: %s", key.Name)
+		}
+		names[key.Name] = true
+
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := store.keys[hash]; ok {
+			return nil, fmt.Errorf("la API key %s repite el valor de otra", key.Name)
+		}
+		for _, format := range key.AllowedFormats {
+			switch format {
+			case domain.JPEG, domain.PNG, domain.WEBP:
+			default:
+				return nil, fmt.Errorf("formato %q no soportado en la API key %s", format, key.Name)
+			}
+		}
+		store.keys[hash] = &key
+	}
+	return store, nil
+}
+
+// LoadAPIKeys lee las claves del archivo JSON path (domain.APIKeysConfig) y de list, una lista
+// separada por comas de "nombre:clave" o solo "clave". Las claves de list usan los límites
+// globales. path y list pueden estar vacíos.
+func LoadAPIKeys(path, list string) ([]domain.APIKey, error) {
+	var keys []domain.APIKey
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error leyendo el archivo de API keys: %w", err)
+		}
+		var config domain.APIKeysConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error decodificando el archivo de API keys: %w", err)
+		}
+		keys = append(keys, config.Keys...)
+	}
+
+	for i, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			name, value = fmt.Sprintf("key-%d", i+1), entry
+		}
+		keys = append(keys, domain.APIKey{Name: name, Key: value})
+	}
+	return keys, nil
+}
+
+// Authenticate devuelve la clave cuyo valor es key
+func (s *APIKeyStore) Authenticate(key string) (*domain.APIKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	found, ok := s.keys[sha256.Sum256([]byte(key))]
+	return found, ok
+}
+
+// Len devuelve el número de claves configuradas. Acepta un almacén nil (sin claves).
+func (s *APIKeyStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.keys)
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// createArchive crea un archivo completo en memoria usando el writer del servicio.
+// Las entradas se escriben en el orden de files.
+func createArchive(service domain.ArchiveService, files []domain.ImageData) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no hay archivos para comprimir")
+	}
+
+	var buf bytes.Buffer
+	writer := service.NewWriter(&buf)
+
+	// Agregar cada archivo
+	for _, file := range files {
+		if _, err := writer.AddFile(file.Filename, file.Data); err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+
+	// Cerrar el writer
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// archiveModTime es la fecha de modificación de todas las entradas, fija para que lotes
+// idénticos generen archivos idénticos byte a byte. 1980-01-01 es la fecha mínima de ZIP.
+var archiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// compressionLevel es el nivel de deflate usado en ZIP y en gzip, fijo por el mismo motivo
+const compressionLevel = flate.DefaultCompression
+
+// Nombres de las entradas del manifiesto dentro del archivo
+const (
+	manifestJSONName = "manifest.json"
+	manifestCSVName  = "manifest.csv"
+)
+
+// writeManifest agrega el manifiesto del lote al archivo en formato JSON y, si se pide, CSV.
+// add escribe la entrada con el nombre exacto, ya reservado por newEntryNames.
+func writeManifest(add func(filename, sanitizedName string, data []byte) error, manifest *domain.BatchManifest, withCSV bool) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error codificando manifiesto: %w", err)
+	}
+	if err := add(manifestJSONName, manifestJSONName, data); err != nil {
+		return err
+	}
+
+	if !withCSV {
+		return nil
+	}
+
+	data, err = encodeManifestCSV(manifest)
+	if err != nil {
+		return fmt.Errorf("error codificando manifiesto CSV: %w", err)
+	}
+	return add(manifestCSVName, manifestCSVName, data)
+}
+
+// encodeManifestCSV codifica el manifiesto como CSV con una fila por imagen
+func encodeManifestCSV(manifest *domain.BatchManifest) ([]byte, error) {
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+
+	header := []string{"input", "output", "input_size", "output_size", "ratio", "width", "height", "format", "requested_format", "quality", "original", "warnings", "error", "error_code"}
+	if err := csvWriter.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range manifest.Images {
+		record := []string{
+			entry.Input,
+			entry.Output,
+			strconv.FormatInt(entry.InputSize, 10),
+			strconv.FormatInt(entry.OutputSize, 10),
+			strconv.FormatFloat(entry.Ratio, 'f', -1, 64),
+			strconv.Itoa(entry.Width),
+			strconv.Itoa(entry.Height),
+			string(entry.Format),
+			string(entry.RequestedFormat),
+			strconv.Itoa(entry.Quality),
+			strconv.FormatBool(entry.Original),
+			strings.Join(entry.Warnings, "; "),
+			entry.Error,
+			entry.ErrorCode,
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// entryNames registra los nombres ya usados en un archivo para evitar entradas duplicadas.
+// La comparación no distingue mayúsculas, ya que al extraer en Windows o macOS
+// "Foto.jpg" y "foto.jpg" también se sobrescribirían.
+type entryNames map[string]struct{}
+
+// newEntryNames crea el registro reservando los nombres del manifiesto, que siempre se
+// escribe al final y debe conservar su nombre
+func newEntryNames() entryNames {
+	return entryNames{
+		manifestJSONName: {},
+		manifestCSVName:  {},
+	}
+}
+
+// unique devuelve name si está libre o, si no, la primera variante "name (n).ext" libre,
+// y la marca como usada
+func (n entryNames) unique(name string) string {
+	candidate := name
+	if _, used := n[strings.ToLower(candidate)]; used {
+		dir, base := path.Split(name)
+		ext := path.Ext(base)
+		stem := strings.TrimSuffix(base, ext)
+		// En los archivos ocultos (".env") el punto inicial no separa una extensión
+		if stem == "" {
+			stem, ext = base, ""
+		}
+		for i := 1; ; i++ {
+			candidate = fmt.Sprintf("%s%s (%d)%s", dir, stem, i, ext)
+			if _, used := n[strings.ToLower(candidate)]; !used {
+				break
+			}
+		}
+	}
+	n[strings.ToLower(candidate)] = struct{}{}
+	return candidate
+}
+
+// flusherOf devuelve el Flush del destino si lo implementa (por ejemplo http.ResponseWriter)
+func flusherOf(w io.Writer) interface{ Flush() } {
+	flusher, _ := w.(interface{ Flush() })
+	return flusher
+}
+
+// sanitizeFilename limpia el nombre del archivo para evitar problemas de seguridad
+func sanitizeFilename(filename string) string {
+	// Obtener solo el nombre del archivo sin la ruta
+	baseName := filepath.Base(filename)
+
+	// Reemplazar caracteres problemáticos
+	baseName = sanitizeSegment(baseName)
+
+	// Si el nombre está vacío después de la limpieza, usar un nombre por defecto
+	if baseName == "" || baseName == "." {
+		baseName = "image"
+	}
+
+	return baseName
+}
+
+// sanitizePath limpia una ruta relativa conservando sus carpetas. A diferencia de
+// sanitizeFilename rechaza (en lugar de aplanar) las rutas absolutas o con "..",
+// ya que provienen de archivos subidos que podrían intentar escribir fuera del destino.
+func sanitizePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+
+	// Rechazar rutas absolutas y con unidad de Windows
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", domain.ErrUnsafeArchivePath
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", domain.ErrUnsafeArchivePath
+		}
+
+		segment = sanitizeSegment(segment)
+		if segment == "" || segment == "." {
+			return "", domain.ErrUnsafeArchivePath
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return "", domain.ErrUnsafeArchivePath
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// sanitizeSegment reemplaza los caracteres problemáticos de un nombre sin separadores
+func sanitizeSegment(name string) string {
+	name = strings.ReplaceAll(name, "..", "")
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	name = strings.ReplaceAll(name, ":", "_")
+	name = strings.ReplaceAll(name, "*", "_")
+	name = strings.ReplaceAll(name, "?", "_")
+	name = strings.ReplaceAll(name, "\"", "_")
+	name = strings.ReplaceAll(name, "<", "_")
+	name = strings.ReplaceAll(name, ">", "_")
+	name = strings.ReplaceAll(name, "|", "_")
+	return name
+}
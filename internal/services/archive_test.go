@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// testManifest es un lote con on_error=skip: una imagen comprimida con caracteres que CSV
+// debe escapar y una que falló
+func testManifest() *domain.BatchManifest {
+	return &domain.BatchManifest{
+		Succeeded: 1,
+		Failed:    1,
+		Images: []domain.ManifestEntry{
+			{
+				Input:           `vacaciones, "playa".png`,
+				Output:          `vacaciones, "playa".jpg`,
+				InputSize:       2000,
+				OutputSize:      500,
+				Ratio:           0.25,
+				Width:           640,
+				Height:          480,
+				Format:          domain.JPEG,
+				RequestedFormat: domain.WEBP,
+				Quality:         80,
+				Warnings:        []string{"WEBP no soportado, se codificó como JPEG", "otro aviso"},
+			},
+			{
+				Input:     "rota.jpg",
+				InputSize: 10,
+				Quality:   80,
+				Error:     "Imagen inválida: datos de imagen inválidos",
+				ErrorCode: "invalid_image_data",
+			},
+		},
+	}
+}
+
+// manifestFiles escribe el manifiesto con writeManifest y devuelve las entradas creadas
+func manifestFiles(t *testing.T, manifest *domain.BatchManifest, withCSV bool) (names []string, files map[string][]byte) {
+	t.Helper()
+	files = make(map[string][]byte)
+	err := writeManifest(func(filename, sanitizedName string, data []byte) error {
+		names = append(names, sanitizedName)
+		files[sanitizedName] = data
+		return nil
+	}, manifest, withCSV)
+	if err != nil {
+		t.Fatalf("writeManifest: %v", err)
+	}
+	return names, files
+}
+
+func TestWriteManifestEntries(t *testing.T) {
+	tests := []struct {
+		withCSV bool
+		want    []string
+	}{
+		{withCSV: false, want: []string{manifestJSONName}},
+		{withCSV: true, want: []string{manifestJSONName, manifestCSVName}},
+	}
+	for _, tt := range tests {
+		names, _ := manifestFiles(t, testManifest(), tt.withCSV)
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("withCSV=%v escribió %v, se esperaba %v", tt.withCSV, names, tt.want)
+		}
+	}
+
+	errWrite := errors.New("disco lleno")
+	err := writeManifest(func(string, string, []byte) error { return errWrite }, testManifest(), true)
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("writeManifest = %v, se esperaba %v", err, errWrite)
+	}
+}
+
+func TestManifestJSON(t *testing.T) {
+	_, files := manifestFiles(t, testManifest(), false)
+
+	var decoded domain.BatchManifest
+	if err := json.Unmarshal(files[manifestJSONName], &decoded); err != nil {
+		t.Fatalf("manifest.json no es JSON válido: %v", err)
+	}
+	if decoded.Succeeded != 1 || decoded.Failed != 1 || len(decoded.Images) != 2 {
+		t.Fatalf("manifiesto decodificado inesperado: %+v", decoded)
+	}
+	if decoded.Images[0].Input != `vacaciones, "playa".png` || !slices.Equal(decoded.Images[0].Warnings, testManifest().Images[0].Warnings) {
+		t.Errorf("la primera entrada no sobrevivió a la codificación: %+v", decoded.Images[0])
+	}
+
+	// El orden de los campos es parte del formato: quien lee el manifiesto lo ve tal cual
+	var raw struct {
+		Images []json.RawMessage `json:"images"`
+	}
+	if err := json.Unmarshal(files[manifestJSONName], &raw); err != nil {
+		t.Fatal(err)
+	}
+	wantKeys := [][]string{
+		{"input", "output", "input_size", "output_size", "ratio", "width", "height", "format", "requested_format", "quality", "original", "warnings"},
+		{"input", "output", "input_size", "output_size", "ratio", "width", "height", "format", "quality", "original", "error", "error_code"},
+	}
+	for i, image := range raw.Images {
+		if keys := jsonKeys(t, image); !slices.Equal(keys, wantKeys[i]) {
+			t.Errorf("entrada %d con campos %v, se esperaba %v", i, keys, wantKeys[i])
+		}
+	}
+	if keys := jsonKeys(t, files[manifestJSONName]); !slices.Equal(keys, []string{"succeeded", "failed", "images"}) {
+		t.Errorf("manifiesto con campos %v", keys)
+	}
+}
+
+// jsonKeys devuelve las claves de primer nivel de un objeto JSON en el orden en que aparecen
+func jsonKeys(t *testing.T, data []byte) []string {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.(string))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestManifestCSV(t *testing.T) {
+	_, files := manifestFiles(t, testManifest(), true)
+	data := files[manifestCSVName]
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("manifest.csv no es CSV válido: %v", err)
+	}
+	want := [][]string{
+		{"input", "output", "input_size", "output_size", "ratio", "width", "height", "format", "requested_format", "quality", "original", "warnings", "error", "error_code"},
+		{`vacaciones, "playa".png`, `vacaciones, "playa".jpg`, "2000", "500", "0.25", "640", "480", "jpeg", "webp", "80", "false", "WEBP no soportado, se codificó como JPEG; otro aviso", "", ""},
+		{"rota.jpg", "", "10", "0", "0", "0", "0", "", "", "80", "false", "", "Imagen inválida: datos de imagen inválidos", "invalid_image_data"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("el CSV tiene %d filas, se esperaban %d", len(records), len(want))
+	}
+	for i := range want {
+		if !slices.Equal(records[i], want[i]) {
+			t.Errorf("fila %d:\n  %q\nse esperaba\n  %q", i, records[i], want[i])
+		}
+	}
+
+	// Las comas y comillas se escapan según RFC 4180
+	wantLine := `"vacaciones, ""playa"".png","vacaciones, ""playa"".jpg",2000,`
+	if !bytes.Contains(data, []byte(wantLine)) {
+		t.Errorf("el CSV no escapa los nombres:\n%s", data)
+	}
+}
+
+func TestManifestCSVEmpty(t *testing.T) {
+	data, err := encodeManifestCSV(&domain.BatchManifest{Images: []domain.ManifestEntry{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("un lote vacío debe tener solo la cabecera, se obtuvo %q (%v)", records, err)
+	}
+}
+
+func TestEntryNamesUnique(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{
+			name:  "repetidos",
+			names: []string{"foto.jpg", "foto.jpg", "foto.jpg"},
+			want:  []string{"foto.jpg", "foto (1).jpg", "foto (2).jpg"},
+		},
+		{
+			name:  "sufijo (n) existente",
+			names: []string{"foto.jpg", "foto (1).jpg", "foto.jpg", "foto (1).jpg"},
+			want:  []string{"foto.jpg", "foto (1).jpg", "foto (2).jpg", "foto (1) (1).jpg"},
+		},
+		{
+			name:  "mayúsculas",
+			names: []string{"Foto.JPG", "foto.jpg", "FOTO (1).jpg"},
+			want:  []string{"Foto.JPG", "foto (1).jpg", "FOTO (1) (1).jpg"},
+		},
+		{
+			name:  "sin extensión",
+			names: []string{"LEEME", "LEEME", "leeme"},
+			want:  []string{"LEEME", "LEEME (1)", "leeme (2)"},
+		},
+		{
+			name:  "archivo oculto",
+			names: []string{".env", ".env"},
+			want:  []string{".env", ".env (1)"},
+		},
+		{
+			name:  "varias extensiones",
+			names: []string{"fotos.tar.gz", "fotos.tar.gz"},
+			want:  []string{"fotos.tar.gz", "fotos.tar (1).gz"},
+		},
+		{
+			name:  "carpetas",
+			names: []string{"a/foto.jpg", "b/foto.jpg", "a/foto.jpg"},
+			want:  []string{"a/foto.jpg", "b/foto.jpg", "a/foto (1).jpg"},
+		},
+		{
+			name:  "nombres del manifiesto reservados",
+			names: []string{"manifest.json", "Manifest.CSV"},
+			want:  []string{"manifest (1).json", "Manifest (1).CSV"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := newEntryNames()
+			var got []string
+			for _, name := range tt.names {
+				got = append(got, names.unique(name))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("unique(%q) = %q, se esperaba %q", tt.names, got, tt.want)
+			}
+		})
+	}
+}
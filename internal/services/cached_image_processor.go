@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CachedImageProcessor decora un domain.ImageProcessor guardando los resultados de
+// CompressImage por contenido: la clave es el SHA-256 de la imagen junto con las opciones
+// normalizadas, así que la misma imagen con las mismas opciones no se vuelve a comprimir.
+// Tiene un nivel en memoria y, opcionalmente, otro en disco que sobrevive a los reinicios.
+// Los errores no se guardan.
+type CachedImageProcessor struct {
+	domain.ImageProcessor
+	memory  *lruCache[*domain.CompressionResult]
+	disk    *diskCache // nil si no hay nivel en disco
+	metrics domain.MetricsRecorder
+}
+
+// NewCachedImageProcessor envuelve next con una caché de memoryBytes bytes en memoria. Si dir
+// no está vacío agrega un nivel en disco de diskBytes bytes en ese directorio, recuperando
+// los resultados guardados en ejecuciones anteriores. metrics recibe los aciertos y fallos
+// de la caché; puede ser nil.
+func NewCachedImageProcessor(next domain.ImageProcessor, memoryBytes int64, dir string, diskBytes int64, metrics domain.MetricsRecorder) (*CachedImageProcessor, error) {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	processor := &CachedImageProcessor{
+		ImageProcessor: next,
+		memory:         newLRUCache[*domain.CompressionResult](memoryBytes, nil),
+		metrics:        metrics,
+	}
+	if dir != "" {
+		disk, err := newDiskCache(dir, diskBytes)
+		if err != nil {
+			return nil, err
+		}
+		processor.disk = disk
+	}
+	return processor, nil
+}
+
+// CompressImage devuelve el resultado guardado para la imagen y las opciones o, si no
+// existe, comprime la imagen y lo guarda. El campo Cache del resultado indica cuál de
+// los dos casos ocurrió. Los datos del resultado se comparten y no deben modificarse.
+func (p *CachedImageProcessor) CompressImage(ctx context.Context, imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) (*domain.CompressionResult, error) {
+	key := compressionCacheKey(imageData, quality, format, ifLarger)
+
+	_, span := StartSpan(ctx, "cache.lookup")
+	if result, ok := p.memory.get(key); ok {
+		span.SetAttributes(attribute.String("cache.result", string(domain.CacheHit)), attribute.String("cache.tier", "memory"))
+		span.End()
+		p.metrics.ObserveCache(domain.CacheHit, "memory")
+		return cachedResult(result, domain.CacheHit), nil
+	}
+	if p.disk != nil {
+		if result, ok := p.disk.get(key); ok {
+			p.memory.add(key, result, int64(len(result.Data)))
+			span.SetAttributes(attribute.String("cache.result", string(domain.CacheHit)), attribute.String("cache.tier", "disk"))
+			span.End()
+			p.metrics.ObserveCache(domain.CacheHit, "disk")
+			return cachedResult(result, domain.CacheHit), nil
+		}
+	}
+	span.SetAttributes(attribute.String("cache.result", string(domain.CacheMiss)))
+	span.End()
+	p.metrics.ObserveCache(domain.CacheMiss, "none")
+
+	result, err := p.ImageProcessor.CompressImage(ctx, imageData, quality, format, ifLarger)
+	if err != nil {
+		return nil, err
+	}
+
+	p.memory.add(key, result, int64(len(result.Data)))
+	if p.disk != nil {
+		p.disk.put(key, result)
+	}
+	return cachedResult(result, domain.CacheMiss), nil
+}
+
+// compressionCacheKey calcula la clave de caché de una compresión. ifLarger vacío equivale
+// a original. Los strings se escriben entre comillas para que la codificación no sea ambigua.
+func compressionCacheKey(imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) string {
+	if ifLarger == "" {
+		ifLarger = domain.IfLargerOriginal
+	}
+
+	sum := sha256.Sum256(imageData)
+	hash := sha256.New()
+	hash.Write(sum[:])
+	fmt.Fprintf(hash, "quality=%d format=%q if_larger=%q", quality, format, ifLarger)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// CompressionETag devuelve el ETag fuerte del resultado de comprimir imageData con las
+// opciones dadas: la clave de caché entre comillas. Se puede calcular sin comprimir la imagen.
+func CompressionETag(imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) string {
+	return `"` + compressionCacheKey(imageData, quality, format, ifLarger) + `"`
+}
+
+// cachedResult devuelve una copia del resultado con el estado de caché indicado, para que
+// quien llama pueda modificar los campos sin afectar a la caché
+func cachedResult(result *domain.CompressionResult, status domain.CacheStatus) *domain.CompressionResult {
+	copied := *result
+	copied.Warnings = slices.Clone(result.Warnings)
+	copied.Cache = status
+	return &copied
+}
+
+// diskCache es el nivel en disco de la caché. Cada resultado se guarda codificado con gob
+// en "<dir>/<2 primeros caracteres de la clave>/<clave>"; el índice LRU vive en memoria y
+// se reconstruye al arrancar según la fecha de modificación de los archivos.
+type diskCache struct {
+	storage *FileStorage
+	index   *lruCache[struct{}]
+}
+
+// newDiskCache abre la caché en dir, descartando los archivos más antiguos si superan maxBytes
+func newDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &diskCache{storage: storage}
+	cache.index = newLRUCache(maxBytes, func(key string, _ struct{}) {
+		cache.delete(key)
+	})
+
+	type cachedFile struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	err = filepath.WalkDir(dir, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		// Restos de escrituras interrumpidas
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			os.Remove(filename)
+			return nil
+		}
+		// Los ETag que FileStorage guarda junto a cada entrada no son entradas
+		if strings.HasPrefix(entry.Name(), ".") && strings.HasSuffix(entry.Name(), etagSuffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{key: filepath.ToSlash(rel), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo la caché en disco: %w", err)
+	}
+
+	// Agregar del más antiguo al más reciente, para que sean los antiguos los que se descarten
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if !cache.index.add(file.key, struct{}{}, file.size) {
+			cache.delete(file.key)
+		}
+	}
+
+	return cache, nil
+}
+
+// get lee el resultado guardado con la clave key
+func (c *diskCache) get(key string) (*domain.CompressionResult, bool) {
+	fileKey := diskCacheKey(key)
+	if _, ok := c.index.get(fileKey); !ok {
+		return nil, false
+	}
+
+	reader, _, err := c.storage.Get(context.Background(), fileKey)
+	if err != nil {
+		c.index.remove(fileKey)
+		return nil, false
+	}
+	defer reader.Close()
+
+	var result domain.CompressionResult
+	if err := gob.NewDecoder(reader).Decode(&result); err != nil {
+		slog.Warn("Descartando entrada de caché inválida", "key", fileKey, "error", err)
+		c.index.remove(fileKey)
+		c.delete(fileKey)
+		return nil, false
+	}
+	return &result, true
+}
+
+// put guarda el resultado con la clave key. Los errores solo se registran: la caché es opcional.
+func (c *diskCache) put(key string, result *domain.CompressionResult) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(result); err != nil {
+		slog.Warn("Error codificando entrada de caché", "error", err)
+		return
+	}
+
+	fileKey := diskCacheKey(key)
+	if _, err := c.storage.Put(context.Background(), fileKey, buf.Bytes(), ""); err != nil {
+		slog.Warn("Error guardando entrada de caché", "key", fileKey, "error", err)
+		return
+	}
+	if !c.index.add(fileKey, struct{}{}, int64(buf.Len())) {
+		c.delete(fileKey)
+	}
+}
+
+// delete elimina el archivo de una entrada
+func (c *diskCache) delete(fileKey string) {
+	if err := c.storage.Delete(context.Background(), fileKey); err != nil {
+		slog.Warn("Error eliminando entrada de caché", "key", fileKey, "error", err)
+	}
+}
+
+// diskCacheKey reparte las entradas en subdirectorios para no acumular miles de archivos en uno
+func diskCacheKey(key string) string {
+	return key[:2] + "/" + key
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// countingProcessor es un domain.ImageProcessor falso que cuenta las compresiones. Cada
+// resultado tiene size bytes iguales a la calidad pedida, o falla con err si no es nil. Si
+// release no es nil, cada compresión espera a que se cierre o a que se cancele su contexto.
+type countingProcessor struct {
+	size    int
+	err     error
+	release chan struct{}
+
+	mu       sync.Mutex
+	calls    int
+	canceled int // Compresiones que terminaron por la cancelación de su contexto
+}
+
+func (p *countingProcessor) CompressImage(ctx context.Context, imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) (*domain.CompressionResult, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.canceled++
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	data := bytes.Repeat([]byte{byte(quality)}, p.size)
+	return &domain.CompressionResult{Data: data, Size: int64(len(data)), Format: format}, nil
+}
+
+func (p *countingProcessor) ValidateImage(ctx context.Context, imageData []byte) error {
+	return nil
+}
+
+func (p *countingProcessor) GetImageInfo(ctx context.Context, imageData []byte) (int, int, domain.ImageFormat, error) {
+	return 1, 1, domain.JPEG, nil
+}
+
+func (p *countingProcessor) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func (p *countingProcessor) canceledCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.canceled
+}
+
+// compressCached comprime con el procesador y comprueba el estado de caché del resultado
+func compressCached(t *testing.T, processor domain.ImageProcessor, image string, quality int, want domain.CacheStatus) *domain.CompressionResult {
+	t.Helper()
+	result, err := processor.CompressImage(context.Background(), []byte(image), quality, domain.JPEG, "")
+	if err != nil {
+		t.Fatalf("CompressImage(%s, %d): %v", image, quality, err)
+	}
+	if result.Cache != want {
+		t.Fatalf("CompressImage(%s, %d) = %s, se esperaba %s", image, quality, result.Cache, want)
+	}
+	return result
+}
+
+func TestCachedImageProcessorHitAndMiss(t *testing.T) {
+	next := &countingProcessor{size: 10}
+	metrics := &recordingMetrics{}
+	processor, err := NewCachedImageProcessor(next, 1<<20, "", 0, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	miss := compressCached(t, processor, "imagen", 80, domain.CacheMiss)
+	miss.Warnings = append(miss.Warnings, "modificado por quien llama")
+	hit := compressCached(t, processor, "imagen", 80, domain.CacheHit)
+
+	if next.count() != 1 {
+		t.Fatalf("el procesador comprimió %d veces, se esperaba 1", next.count())
+	}
+	if !bytes.Equal(hit.Data, miss.Data) || len(hit.Warnings) != 0 {
+		t.Fatalf("el acierto no devolvió el resultado guardado: %+v", hit)
+	}
+	if want := []string{"MISS/none", "HIT/memory"}; !slices.Equal(metrics.lookups, want) {
+		t.Fatalf("consultas registradas %v, se esperaba %v", metrics.lookups, want)
+	}
+}
+
+func TestCachedImageProcessorKey(t *testing.T) {
+	type options struct {
+		image    string
+		quality  int
+		format   domain.ImageFormat
+		ifLarger domain.IfLargerPolicy
+	}
+	base := options{image: "imagen", quality: 80, format: domain.WEBP, ifLarger: domain.IfLargerOriginal}
+
+	tests := []struct {
+		name   string
+		modify func(*options)
+		want   domain.CacheStatus
+	}{
+		{name: "mismas opciones", modify: func(o *options) {}, want: domain.CacheHit},
+		{name: "if_larger vacío equivale a original", modify: func(o *options) { o.ifLarger = "" }, want: domain.CacheHit},
+		{name: "otra imagen", modify: func(o *options) { o.image = "otra imagen" }, want: domain.CacheMiss},
+		{name: "otra calidad", modify: func(o *options) { o.quality = 81 }, want: domain.CacheMiss},
+		{name: "otro formato", modify: func(o *options) { o.format = domain.PNG }, want: domain.CacheMiss},
+		{name: "if_larger keep", modify: func(o *options) { o.ifLarger = domain.IfLargerKeep }, want: domain.CacheMiss},
+		{name: "if_larger error", modify: func(o *options) { o.ifLarger = domain.IfLargerError }, want: domain.CacheMiss},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingProcessor{size: 10}
+			processor, err := NewCachedImageProcessor(next, 1<<20, "", 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compress := func(o options) *domain.CompressionResult {
+				result, err := processor.CompressImage(context.Background(), []byte(o.image), o.quality, o.format, o.ifLarger)
+				if err != nil {
+					t.Fatalf("CompressImage: %v", err)
+				}
+				return result
+			}
+
+			compress(base)
+			variant := base
+			tt.modify(&variant)
+			if got := compress(variant).Cache; got != tt.want {
+				t.Fatalf("la variante dio %s, se esperaba %s", got, tt.want)
+			}
+			if tt.want == domain.CacheHit && next.count() != 1 {
+				t.Fatalf("el procesador comprimió %d veces en un acierto", next.count())
+			}
+		})
+	}
+}
+
+func TestCachedImageProcessorMemoryBudget(t *testing.T) {
+	next := &countingProcessor{size: 100}
+	processor, err := NewCachedImageProcessor(next, 250, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressCached(t, processor, "a", 80, domain.CacheMiss)
+	compressCached(t, processor, "b", 80, domain.CacheMiss)
+	compressCached(t, processor, "a", 80, domain.CacheHit)
+	// c no cabe con a y b: se descarta b, el usado hace más tiempo
+	compressCached(t, processor, "c", 80, domain.CacheMiss)
+	compressCached(t, processor, "a", 80, domain.CacheHit)
+	compressCached(t, processor, "b", 80, domain.CacheMiss)
+
+	if next.count() != 4 {
+		t.Fatalf("el procesador comprimió %d veces, se esperaban 4", next.count())
+	}
+}
+
+func TestCachedImageProcessorDoesNotCacheErrors(t *testing.T) {
+	errFailed := errors.New("la compresión falló")
+	next := &countingProcessor{err: errFailed}
+	processor, err := NewCachedImageProcessor(next, 1<<20, t.TempDir(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := processor.CompressImage(context.Background(), []byte("imagen"), 80, domain.JPEG, ""); !errors.Is(err, errFailed) {
+			t.Fatalf("CompressImage = %v, se esperaba %v", err, errFailed)
+		}
+	}
+	if next.count() != 2 {
+		t.Fatalf("el procesador comprimió %d veces, los errores no deben guardarse", next.count())
+	}
+}
+
+func TestCachedImageProcessorDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewCachedImageProcessor(&countingProcessor{size: 10}, 1<<20, dir, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miss := compressCached(t, first, "imagen", 80, domain.CacheMiss)
+
+	// Otra instancia sobre el mismo directorio simula un reinicio: la memoria está vacía
+	next := &countingProcessor{size: 10}
+	metrics := &recordingMetrics{}
+	second, err := NewCachedImageProcessor(next, 1<<20, dir, 1<<20, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(second.disk.index.items); n != 1 {
+		t.Fatalf("el índice en disco tiene %d entradas, se esperaba 1 (sin los archivos de ETag)", n)
+	}
+
+	fromDisk := compressCached(t, second, "imagen", 80, domain.CacheHit)
+	compressCached(t, second, "imagen", 80, domain.CacheHit)
+
+	if next.count() != 0 {
+		t.Fatalf("el procesador comprimió %d veces, el resultado estaba en disco", next.count())
+	}
+	if !bytes.Equal(fromDisk.Data, miss.Data) || fromDisk.Format != miss.Format {
+		t.Fatalf("el resultado leído de disco %+v no coincide con el guardado %+v", fromDisk, miss)
+	}
+	if want := []string{"HIT/disk", "HIT/memory"}; !slices.Equal(metrics.lookups, want) {
+		t.Fatalf("consultas registradas %v, se esperaba %v", metrics.lookups, want)
+	}
+}
+
+func TestCachedImageProcessorDiskBudget(t *testing.T) {
+	dir := t.TempDir()
+	next := &countingProcessor{size: 1000}
+	// Sin memoria, y en disco solo cabe una entrada de algo más de 1000 bytes codificada
+	processor, err := NewCachedImageProcessor(next, 0, dir, 1500, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressCached(t, processor, "a", 80, domain.CacheMiss)
+	compressCached(t, processor, "a", 80, domain.CacheHit)
+	compressCached(t, processor, "b", 80, domain.CacheMiss)
+	compressCached(t, processor, "a", 80, domain.CacheMiss)
+
+	if next.count() != 3 {
+		t.Fatalf("el procesador comprimió %d veces, se esperaban 3", next.count())
+	}
+
+	// Los archivos descartados se eliminan junto con su ETag
+	var entries, etags int
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil || entry.IsDir():
+			return err
+		case strings.HasSuffix(entry.Name(), etagSuffix):
+			etags++
+		default:
+			entries++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 || etags != 1 {
+		t.Fatalf("quedaron %d entradas y %d ETag en disco, se esperaba 1 de cada una", entries, etags)
+	}
+}
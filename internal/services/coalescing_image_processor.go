@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CoalescingImageProcessor decora un domain.ImageProcessor para que las compresiones
+// idénticas (misma imagen y mismas opciones) que llegan a la vez se calculen una sola vez:
+// la primera comprime y las demás esperan y reciben una copia de su resultado o su error.
+//
+// La compresión compartida no depende del contexto de ninguna petición en particular: si
+// quien la inició se cancela, las demás siguen esperando el resultado. Cada petición deja
+// de esperar cuando se cancela su propio contexto, y la compresión se cancela cuando ya no
+// la espera nadie. Los errores no se guardan: la siguiente llamada vuelve a comprimir.
+type CoalescingImageProcessor struct {
+	domain.ImageProcessor
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall // Compresiones en curso por clave de caché
+}
+
+// coalescedCall es una compresión en curso compartida por waiters peticiones
+type coalescedCall struct {
+	done     chan struct{} // Se cierra al terminar la compresión
+	result   *domain.CompressionResult
+	err      error
+	panicked any // Valor de un panic en la compresión, que se repite en cada petición
+
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCoalescingImageProcessor envuelve next
+func NewCoalescingImageProcessor(next domain.ImageProcessor) *CoalescingImageProcessor {
+	return &CoalescingImageProcessor{ImageProcessor: next, calls: make(map[string]*coalescedCall)}
+}
+
+// CompressImage comprime la imagen o, si ya hay una compresión idéntica en curso, espera su
+// resultado. Los spans de la compresión cuelgan de la petición que la inició; las que se
+// unieron a una compresión en curso marcan su span actual con image.coalesced.
+func (p *CoalescingImageProcessor) CompressImage(ctx context.Context, imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) (*domain.CompressionResult, error) {
+	key := compressionCacheKey(imageData, quality, format, ifLarger)
+
+	p.mu.Lock()
+	call, shared := p.calls[key]
+	if !shared {
+		// Conserva los valores del contexto (el span) pero no su cancelación
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		p.calls[key] = call
+		go p.compress(callCtx, key, call, imageData, quality, format, ifLarger)
+	}
+	call.waiters++
+	p.mu.Unlock()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("image.coalesced", shared))
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		p.leave(key, call)
+		return nil, ctx.Err()
+	}
+
+	if call.panicked != nil {
+		panic(call.panicked)
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	// Cada llamador recibe su propia copia de los campos
+	return cachedResult(call.result, call.result.Cache), nil
+}
+
+// compress ejecuta la compresión compartida y la retira de las compresiones en curso
+func (p *CoalescingImageProcessor) compress(ctx context.Context, key string, call *coalescedCall, imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) {
+	defer func() {
+		call.panicked = recover()
+		call.cancel()
+
+		p.mu.Lock()
+		if p.calls[key] == call {
+			delete(p.calls, key)
+		}
+		p.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.result, call.err = p.ImageProcessor.CompressImage(ctx, imageData, quality, format, ifLarger)
+}
+
+// leave retira a una petición que dejó de esperar. Si era la última, cancela la compresión y
+// la retira de las compresiones en curso para que la siguiente petición empiece otra.
+func (p *CoalescingImageProcessor) leave(key string, call *coalescedCall) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		if p.calls[key] == call {
+			delete(p.calls, key)
+		}
+	}
+}
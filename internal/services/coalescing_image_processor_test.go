@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// waitWaiters espera a que n peticiones estén esperando la compresión de image
+func waitWaiters(t *testing.T, processor *CoalescingImageProcessor, image string, n int) {
+	t.Helper()
+	key := compressionCacheKey([]byte(image), 80, domain.JPEG, "")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		processor.mu.Lock()
+		call := processor.calls[key]
+		waiting := call != nil && call.waiters == n
+		processor.mu.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no hay %d peticiones esperando la compresión", n)
+}
+
+// coalescedResult es lo que recibe una petición de CoalescingImageProcessor
+type coalescedResult struct {
+	result *domain.CompressionResult
+	err    error
+}
+
+// compressConcurrently lanza una compresión de image por contexto, en orden y esperando a que
+// cada una se una a la compresión en curso, de modo que la primera es la que la inicia.
+// Devuelve una función que espera los resultados en el orden de contexts.
+func compressConcurrently(t *testing.T, processor *CoalescingImageProcessor, image string, contexts []context.Context) func() []coalescedResult {
+	t.Helper()
+	results := make([]coalescedResult, len(contexts))
+	var wg sync.WaitGroup
+	for i, ctx := range contexts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].result, results[i].err = processor.CompressImage(ctx, []byte(image), 80, domain.JPEG, "")
+		}()
+		waitWaiters(t, processor, image, i+1)
+	}
+	return func() []coalescedResult {
+		wg.Wait()
+		return results
+	}
+}
+
+// backgrounds devuelve n contextos que no se cancelan
+func backgrounds(n int) []context.Context {
+	contexts := make([]context.Context, n)
+	for i := range contexts {
+		contexts[i] = context.Background()
+	}
+	return contexts
+}
+
+func TestCoalescingImageProcessorSharesResult(t *testing.T) {
+	const callers = 8
+	next := &countingProcessor{size: 10, release: make(chan struct{})}
+	processor := NewCoalescingImageProcessor(next)
+
+	wait := compressConcurrently(t, processor, "imagen", backgrounds(callers))
+	close(next.release)
+	results := wait()
+
+	if next.count() != 1 {
+		t.Fatalf("el procesador comprimió %d veces, se esperaba 1", next.count())
+	}
+	for i, got := range results {
+		if got.err != nil {
+			t.Fatalf("petición %d: %v", i, got.err)
+		}
+		if !bytes.Equal(got.result.Data, results[0].result.Data) {
+			t.Fatalf("la petición %d recibió otro resultado", i)
+		}
+		if i > 0 && got.result == results[0].result {
+			t.Fatalf("las peticiones 0 y %d comparten el mismo *CompressionResult", i)
+		}
+	}
+
+	// Terminada la compresión, la siguiente petición comprime de nuevo
+	if _, err := processor.CompressImage(context.Background(), []byte("imagen"), 80, domain.JPEG, ""); err != nil || next.count() != 2 {
+		t.Fatalf("CompressImage = %v con %d compresiones, se esperaban 2", err, next.count())
+	}
+}
+
+func TestCoalescingImageProcessorSharesError(t *testing.T) {
+	const callers = 4
+	errFailed := errors.New("la compresión falló")
+	next := &countingProcessor{err: errFailed, release: make(chan struct{})}
+	processor := NewCoalescingImageProcessor(next)
+
+	wait := compressConcurrently(t, processor, "imagen", backgrounds(callers))
+	close(next.release)
+	for i, got := range wait() {
+		if !errors.Is(got.err, errFailed) || got.result != nil {
+			t.Fatalf("petición %d: %v, se esperaba %v", i, got.err, errFailed)
+		}
+	}
+	if next.count() != 1 {
+		t.Fatalf("el procesador comprimió %d veces, se esperaba 1", next.count())
+	}
+
+	// El error no se guarda
+	if _, err := processor.CompressImage(context.Background(), []byte("imagen"), 80, domain.JPEG, ""); !errors.Is(err, errFailed) || next.count() != 2 {
+		t.Fatalf("CompressImage = %v con %d compresiones, se esperaban 2", err, next.count())
+	}
+}
+
+func TestCoalescingImageProcessorCancellation(t *testing.T) {
+	tests := []struct {
+		name         string
+		canceled     []bool // Peticiones que se cancelan mientras esperan
+		wantCanceled int    // Compresiones canceladas
+	}{
+		{name: "se cancela quien inició la compresión", canceled: []bool{true, false, false}},
+		{name: "se cancela una de las que esperan", canceled: []bool{false, true, false}},
+		{name: "se cancelan todas", canceled: []bool{true, true, true}, wantCanceled: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingProcessor{size: 10, release: make(chan struct{})}
+			processor := NewCoalescingImageProcessor(next)
+
+			contexts := make([]context.Context, len(tt.canceled))
+			cancels := make([]context.CancelFunc, len(tt.canceled))
+			for i := range contexts {
+				contexts[i], cancels[i] = context.WithCancel(context.Background())
+				defer cancels[i]()
+			}
+			wait := compressConcurrently(t, processor, "imagen", contexts)
+
+			remaining := len(contexts)
+			for i, canceled := range tt.canceled {
+				if canceled {
+					cancels[i]()
+					remaining--
+					if remaining > 0 {
+						waitWaiters(t, processor, "imagen", remaining)
+					}
+				}
+			}
+			if remaining == 0 {
+				// Nadie espera: la compresión se cancela sin cerrar release
+				deadline := time.Now().Add(5 * time.Second)
+				for next.canceledCount() == 0 && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+			} else {
+				close(next.release)
+			}
+
+			for i, got := range wait() {
+				wantErr := error(nil)
+				if tt.canceled[i] {
+					wantErr = context.Canceled
+				}
+				if !errors.Is(got.err, wantErr) {
+					t.Fatalf("petición %d: %v, se esperaba %v", i, got.err, wantErr)
+				}
+			}
+			if next.count() != 1 || next.canceledCount() != tt.wantCanceled {
+				t.Fatalf("%d compresiones, %d canceladas; se esperaba 1 y %d", next.count(), next.canceledCount(), tt.wantCanceled)
+			}
+
+			// Cancelada o no, la compresión ya no está en curso
+			if remaining == 0 {
+				next.release = nil
+			}
+			if _, err := processor.CompressImage(context.Background(), []byte("imagen"), 80, domain.JPEG, ""); err != nil || next.count() != 2 {
+				t.Fatalf("CompressImage = %v con %d compresiones, se esperaban 2", err, next.count())
+			}
+		})
+	}
+}
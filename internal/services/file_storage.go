@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// etagSuffix es la extensión de los archivos ocultos que guardan el ETag de cada objeto
+const etagSuffix = ".etag"
+
+// FileStorage implementa domain.Storage sobre un directorio local. El ETag de cada objeto se
+// calcula en Put y se guarda junto a él en un archivo oculto (.nombre.etag), para que Get no
+// tenga que leer el objeto entero.
+type FileStorage struct {
+	root string
+}
+
+// NewFileStorage crea un almacenamiento en el directorio root, creándolo si no existe
+func NewFileStorage(root string) (*FileStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("error creando directorio de almacenamiento: %w", err)
+	}
+	return &FileStorage{root: root}, nil
+}
+
+// Put escribe data en root/key y su ETag en el archivo oculto que lo acompaña. El ETag
+// anterior se elimina antes de reemplazar el objeto, para que Get nunca devuelva el ETag de
+// otro contenido; sin él, Get lo recalcula.
+func (s *FileStorage) Put(ctx context.Context, key string, data []byte, contentType string) (*domain.StoredObject, error) {
+	key, err := s.sanitizeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, fmt.Errorf("error creando directorio para %s: %w", key, err)
+	}
+
+	etag := contentETag(data)
+	if err := os.Remove(etagPath(filename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error guardando %s: %w", key, err)
+	}
+	if err := writeFileAtomic(filename, data); err != nil {
+		return nil, fmt.Errorf("error guardando %s: %w", key, err)
+	}
+	if err := writeFileAtomic(etagPath(filename), []byte(etag)); err != nil {
+		return nil, fmt.Errorf("error guardando el ETag de %s: %w", key, err)
+	}
+
+	return &domain.StoredObject{
+		Key:         key,
+		Size:        int64(len(data)),
+		ETag:        etag,
+		ContentType: contentType,
+	}, nil
+}
+
+// Get abre el archivo del objeto key. El tipo de contenido se deduce de la extensión y el
+// ETag se lee del archivo que guardó Put.
+func (s *FileStorage) Get(ctx context.Context, key string) (io.ReadCloser, *domain.StoredObject, error) {
+	key, err := s.sanitizeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, domain.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error leyendo %s: %w", key, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error leyendo %s: %w", key, err)
+	}
+
+	etag, err := readETag(s.path(key), file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error leyendo %s: %w", key, err)
+	}
+
+	return file, &domain.StoredObject{
+		Key:         key,
+		Size:        stat.Size(),
+		ETag:        etag,
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}, nil
+}
+
+// Delete elimina el archivo del objeto key y su ETag; no es un error si no existe
+func (s *FileStorage) Delete(ctx context.Context, key string) error {
+	key, err := s.sanitizeKey(key)
+	if err != nil {
+		return err
+	}
+
+	filename := s.path(key)
+	for _, name := range []string{filename, etagPath(filename)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error eliminando %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// sanitizeKey valida key como sanitizeStorageKey y además rechaza los nombres de los
+// archivos de ETag, para que un objeto no pueda reemplazar el ETag de otro
+func (s *FileStorage) sanitizeKey(key string) (string, error) {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return "", err
+	}
+	if base := path.Base(key); strings.HasPrefix(base, ".") && strings.HasSuffix(base, etagSuffix) {
+		return "", domain.ErrInvalidStorageKey
+	}
+	return key, nil
+}
+
+// path devuelve la ruta del archivo de una clave ya sanitizada
+func (s *FileStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// etagPath devuelve la ruta del archivo oculto con el ETag del objeto guardado en filename
+func etagPath(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+etagSuffix)
+}
+
+// readETag lee el ETag guardado del objeto filename. Si no existe (objetos guardados antes
+// de que Put lo escribiera) lo recalcula del contenido de file y vuelve al principio.
+func readETag(filename string, file *os.File) (string, error) {
+	etag, err := os.ReadFile(etagPath(filename))
+	if err == nil {
+		return string(etag), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// writeFileAtomic escribe data en filename. El archivo se escribe con otro nombre y se
+// renombra al final, para que nunca se lea a medio escribir.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -2,28 +2,58 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
+	"time"
 
 	"github.com/miguelmoralesr13/image-compress/internal/domain"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ImageProcessorService implementa la interfaz ImageProcessor
 type ImageProcessorService struct {
 	maxImageSize int64
+	metrics      domain.MetricsRecorder
 }
 
-// NewImageProcessorService crea una nueva instancia del servicio
-func NewImageProcessorService(maxImageSize int64) *ImageProcessorService {
+// NewImageProcessorService crea una nueva instancia del servicio. metrics recibe las
+// mediciones de cada compresión; puede ser nil.
+func NewImageProcessorService(maxImageSize int64, metrics domain.MetricsRecorder) *ImageProcessorService {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
 	return &ImageProcessorService{
 		maxImageSize: maxImageSize,
+		metrics:      metrics,
 	}
 }
 
-// CompressImage comprime una imagen con la calidad especificada
-func (s *ImageProcessorService) CompressImage(imageData []byte, quality int, format domain.ImageFormat) ([]byte, error) {
+// CompressImage comprime una imagen con la calidad especificada.
+// Si el resultado no es más pequeño que la entrada se aplica la política ifLarger.
+// La decodificación y la codificación se registran como spans separados.
+func (s *ImageProcessorService) CompressImage(ctx context.Context, imageData []byte, quality int, format domain.ImageFormat, ifLarger domain.IfLargerPolicy) (result *domain.CompressionResult, err error) {
+	ctx, span := StartSpan(ctx, "image.compress",
+		attribute.Int("image.input_bytes", len(imageData)),
+		attribute.Int("image.quality", quality),
+		attribute.String("image.requested_format", string(format)),
+	)
+	var inputFormat string
+	defer func() {
+		if err == nil {
+			span.SetAttributes(attribute.Int64("image.output_bytes", result.Size), attribute.Bool("image.original", result.Original))
+		}
+		EndSpan(span, err)
+
+		// Los errores los registran los handlers que rechazan la imagen (Metrics.RecordError)
+		if err != nil {
+			return
+		}
+		s.metrics.ObserveImage(s.convertFormat(inputFormat), result.Format, int64(len(imageData)), result.Size)
+	}()
+
 	if len(imageData) == 0 {
 		return nil, domain.ErrEmptyImageData
 	}
@@ -32,59 +62,144 @@ func (s *ImageProcessorService) CompressImage(imageData []byte, quality int, for
 		return nil, domain.ErrInvalidQuality
 	}
 
+	switch ifLarger {
+	case "", domain.IfLargerKeep, domain.IfLargerOriginal, domain.IfLargerError:
+	default:
+		return nil, domain.ErrInvalidPolicy
+	}
+
 	// Decodificar la imagen usando solo librerías estándar
-	img, _, err := image.Decode(bytes.NewReader(imageData))
+	start := time.Now()
+	_, decodeSpan := StartSpan(ctx, "image.decode")
+	img, inputFormat, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
-		return nil, fmt.Errorf("error decodificando imagen: %w", err)
+		err = fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+		EndSpan(decodeSpan, err)
+		return nil, err
 	}
+	bounds := img.Bounds()
+	decodeSpan.SetAttributes(
+		attribute.String("image.input_format", string(s.convertFormat(inputFormat))),
+		attribute.Int("image.width", bounds.Dx()),
+		attribute.Int("image.height", bounds.Dy()),
+	)
+	EndSpan(decodeSpan, nil)
+	s.metrics.ObserveDecode(s.convertFormat(inputFormat), time.Since(start))
 
 	// Crear buffer para la imagen comprimida
 	var buf bytes.Buffer
 
 	// Comprimir según el formato
+	start = time.Now()
+	_, encodeSpan := StartSpan(ctx, "image.encode")
+	outputFormat := format
+	var warnings []string
 	switch format {
 	case domain.JPEG:
 		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	case domain.PNG:
 		// PNG no tiene calidad, pero podemos optimizar el tamaño
+		warnings = append(warnings, "PNG no usa el parámetro quality")
 		err = png.Encode(&buf, img)
 	case domain.WEBP:
 		// Para WEBP necesitaríamos una librería adicional como go-webp
 		// Por ahora usamos JPEG como fallback
+		outputFormat = domain.JPEG
+		warnings = append(warnings, "WEBP no soportado, se codificó como JPEG")
 		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	default:
 		// Formato por defecto: JPEG
+		outputFormat = domain.JPEG
+		warnings = append(warnings, fmt.Sprintf("formato %q desconocido, se codificó como JPEG", format))
 		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("error codificando imagen: %w", err)
+		err = fmt.Errorf("error codificando imagen: %w", err)
+		EndSpan(encodeSpan, err)
+		return nil, err
+	}
+	encodeSpan.SetAttributes(attribute.String("image.output_format", string(outputFormat)), attribute.Int("image.encoded_bytes", buf.Len()))
+	EndSpan(encodeSpan, nil)
+	s.metrics.ObserveEncode(outputFormat, time.Since(start))
+
+	// Garantizar que el resultado no sea más grande que la entrada
+	if buf.Len() >= len(imageData) {
+		switch ifLarger {
+		case "", domain.IfLargerOriginal:
+			// Devolver la original descarta también el cambio de formato: quien llama
+			// lo ve en RequestedFormat y en el aviso
+			original := &domain.CompressionResult{
+				Data:     imageData,
+				Size:     int64(len(imageData)),
+				Format:   s.convertFormat(inputFormat),
+				Original: true,
+				Width:    bounds.Dx(),
+				Height:   bounds.Dy(),
+				Warnings: []string{"la imagen recomprimida no era más pequeña, se devolvió la original"},
+			}
+			if original.Format != format {
+				original.RequestedFormat = format
+				original.Warnings = []string{fmt.Sprintf("la imagen recomprimida no era más pequeña, se devolvió la original en %s en lugar de %s", original.Format, format)}
+			}
+			return original, nil
+		case domain.IfLargerError:
+			return nil, domain.ErrOutputNotSmaller
+		case domain.IfLargerKeep:
+			warnings = append(warnings, "la imagen recomprimida es más grande que la original")
+		}
 	}
 
-	return buf.Bytes(), nil
+	result = &domain.CompressionResult{
+		Data:     buf.Bytes(),
+		Size:     int64(buf.Len()),
+		Format:   outputFormat,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		Warnings: warnings,
+	}
+	if outputFormat != format {
+		result.RequestedFormat = format
+	}
+	return result, nil
 }
 
-// ValidateImage valida que los datos de imagen sean válidos
-func (s *ImageProcessorService) ValidateImage(imageData []byte) error {
+// ValidateImage valida que los datos de imagen sean válidos. El tamaño máximo es el de la
+// API key de ctx, si lo define, o el global. No registra métricas: el error solo cuenta si
+// un handler rechaza la imagen.
+func (s *ImageProcessorService) ValidateImage(ctx context.Context, imageData []byte) (err error) {
+	_, span := StartSpan(ctx, "image.validate", attribute.Int("image.input_bytes", len(imageData)))
+	defer func() { EndSpan(span, err) }()
+
 	if len(imageData) == 0 {
 		return domain.ErrEmptyImageData
 	}
 
-	if int64(len(imageData)) > s.maxImageSize {
+	if int64(len(imageData)) > domain.APIKeyFromContext(ctx).ImageSizeLimit(s.maxImageSize) {
 		return domain.ErrImageTooLarge
 	}
 
 	// Intentar decodificar la imagen para validar
-	_, _, err := image.Decode(bytes.NewReader(imageData))
-	if err != nil {
+	if _, _, err := image.Decode(bytes.NewReader(imageData)); err != nil {
 		return domain.ErrInvalidImageData
 	}
 
 	return nil
 }
 
+// IsImage indica si data empieza con la cabecera de un formato de imagen que se puede
+// decodificar. No decodifica los píxeles ni registra métricas, así que sirve para separar
+// las imágenes del resto de archivos de un ZIP.
+func IsImage(data []byte) bool {
+	_, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil
+}
+
 // GetImageInfo obtiene información de la imagen
-func (s *ImageProcessorService) GetImageInfo(imageData []byte) (width, height int, format domain.ImageFormat, err error) {
+func (s *ImageProcessorService) GetImageInfo(ctx context.Context, imageData []byte) (width, height int, format domain.ImageFormat, err error) {
+	_, span := StartSpan(ctx, "image.info", attribute.Int("image.input_bytes", len(imageData)))
+	defer func() { EndSpan(span, err) }()
+
 	if len(imageData) == 0 {
 		return 0, 0, "", domain.ErrEmptyImageData
 	}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// testJPEG devuelve testPNG recodificada como JPEG de calidad 10: más pequeña que cualquier
+// PNG o JPEG de mayor calidad que se genere a partir de ella
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(testPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 10}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// noisyPNG devuelve una imagen PNG de 64x64 con ruido, que como JPEG ocupa mucho menos
+func noisyPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	seed := uint32(1)
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			seed = seed*1664525 + 1013904223
+			img.Set(x, y, color.RGBA{uint8(seed >> 24), uint8(seed >> 16), uint8(seed >> 8), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressImageIfLarger(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         func(*testing.T) []byte
+		quality       int
+		format        domain.ImageFormat
+		ifLarger      domain.IfLargerPolicy
+		wantErr       error
+		wantOriginal  bool
+		wantFormat    domain.ImageFormat
+		wantRequested domain.ImageFormat // RequestedFormat esperado
+		wantWarning   string             // Fragmento de alguno de los avisos, "" sin avisos
+	}{
+		{
+			name:  "más pequeña con el formato pedido",
+			input: noisyPNG, quality: 80, format: domain.JPEG, ifLarger: domain.IfLargerOriginal,
+			wantFormat: domain.JPEG,
+		},
+		{
+			name:  "formato no soportado",
+			input: noisyPNG, quality: 80, format: domain.WEBP, ifLarger: domain.IfLargerOriginal,
+			wantFormat: domain.JPEG, wantRequested: domain.WEBP, wantWarning: "WEBP no soportado",
+		},
+		{
+			name:  "original sin cambio de formato",
+			input: testJPEG, quality: 100, format: domain.JPEG, ifLarger: domain.IfLargerOriginal,
+			wantOriginal: true, wantFormat: domain.JPEG, wantWarning: "se devolvió la original",
+		},
+		{
+			name:  "original descarta el cambio de formato",
+			input: testJPEG, quality: 80, format: domain.PNG, ifLarger: domain.IfLargerOriginal,
+			wantOriginal: true, wantFormat: domain.JPEG, wantRequested: domain.PNG, wantWarning: "en jpeg en lugar de png",
+		},
+		{
+			name:  "política vacía equivale a original",
+			input: testJPEG, quality: 80, format: domain.PNG,
+			wantOriginal: true, wantFormat: domain.JPEG, wantRequested: domain.PNG, wantWarning: "en jpeg en lugar de png",
+		},
+		{
+			name:  "keep conserva el formato pedido",
+			input: testJPEG, quality: 80, format: domain.PNG, ifLarger: domain.IfLargerKeep,
+			wantFormat: domain.PNG, wantWarning: "más grande que la original",
+		},
+		{
+			name:  "error",
+			input: testJPEG, quality: 80, format: domain.PNG, ifLarger: domain.IfLargerError,
+			wantErr: domain.ErrOutputNotSmaller,
+		},
+		{
+			name:  "política desconocida",
+			input: testJPEG, quality: 80, format: domain.PNG, ifLarger: "siempre",
+			wantErr: domain.ErrInvalidPolicy,
+		},
+	}
+
+	processor := NewImageProcessorService(1<<20, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input(t)
+			result, err := processor.CompressImage(context.Background(), input, tt.quality, tt.format, tt.ifLarger)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompressImage = %v, se esperaba %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if result.Original != tt.wantOriginal || result.Format != tt.wantFormat || result.RequestedFormat != tt.wantRequested {
+				t.Fatalf("original=%v format=%s requested_format=%q, se esperaba original=%v format=%s requested_format=%q",
+					result.Original, result.Format, result.RequestedFormat, tt.wantOriginal, tt.wantFormat, tt.wantRequested)
+			}
+			if tt.wantOriginal && !bytes.Equal(result.Data, input) {
+				t.Fatalf("el resultado original no son los bytes de entrada")
+			}
+			if !tt.wantOriginal && tt.ifLarger != domain.IfLargerKeep && len(result.Data) >= len(input) {
+				t.Fatalf("el resultado de %d bytes no es más pequeño que la entrada de %d", len(result.Data), len(input))
+			}
+
+			warnings := strings.Join(result.Warnings, "; ")
+			if tt.wantWarning == "" && warnings != "" || !strings.Contains(warnings, tt.wantWarning) {
+				t.Fatalf("avisos %q, se esperaba uno con %q", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
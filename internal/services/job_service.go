@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// JobFunc procesa un trabajo en segundo plano. report debe llamarse cuando cada imagen
+// empieza (image.started) y termina (image.completed o image.failed); se puede llamar
+// desde varios workers a la vez. Si falla puede devolver, junto con el error, un resultado
+// parcial cuyo manifiesto se incluye en la notificación webhook.
+type JobFunc func(ctx context.Context, report func(event domain.JobEvent)) (*domain.JobResult, error)
+
+// JobService ejecuta lotes en segundo plano. El estado de los trabajos se guarda en memoria
+// y el archivo resultante en storage. Los trabajos terminados se eliminan pasado el tiempo ttl.
+type JobService struct {
+	mu        sync.Mutex
+	jobs      map[string]*jobEntry
+	storage   domain.Storage
+	timeout   time.Duration
+	ttl       time.Duration
+	maxActive int // Trabajos en cola o en ejecución a la vez, 0 sin límite
+	active    int
+	notifier  domain.JobNotifier
+	publicURL string // URL base de la API para los enlaces de descarga de las notificaciones
+}
+
+// jobEntry es un trabajo junto con su resultado y sus eventos de progreso
+type jobEntry struct {
+	job     domain.Job
+	result  *domain.JobResult
+	events  []domain.JobEvent
+	changed chan struct{} // Se cierra (y se reemplaza) cada vez que se agrega un evento
+}
+
+// NewJobService crea el servicio. storage guarda los resultados, timeout limita la duración
+// de cada trabajo y ttl el tiempo que se conservan los trabajos terminados. maxActive limita
+// los trabajos sin terminar, que retienen su lote en memoria (0 sin límite). notifier envía
+// las notificaciones de los trabajos con callback_url a través de publicURL, la URL pública de la API.
+func NewJobService(storage domain.Storage, timeout, ttl time.Duration, maxActive int, notifier domain.JobNotifier, publicURL string) *JobService {
+	return &JobService{
+		jobs:      make(map[string]*jobEntry),
+		storage:   storage,
+		timeout:   timeout,
+		ttl:       ttl,
+		maxActive: maxActive,
+		notifier:  notifier,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+// Start crea el trabajo job y lo ejecuta en segundo plano con fn. job indica Total,
+// CallbackURL, Preset y Owner; el resto de campos los asigna Start. Si CallbackURL no está vacía
+// recibe una notificación cuando el trabajo termina. Devuelve el estado inicial del trabajo, o
+// domain.ErrTooManyJobs si ya hay maxActive trabajos sin terminar.
+func (s *JobService) Start(job domain.Job, fn JobFunc) (domain.Job, error) {
+	s.mu.Lock()
+	s.purgeExpired()
+	if s.maxActive > 0 && s.active >= s.maxActive {
+		s.mu.Unlock()
+		return domain.Job{}, fmt.Errorf("%w: máximo %d", domain.ErrTooManyJobs, s.maxActive)
+	}
+	s.active++
+	job.ID = uuid.NewString()
+	job.Status = domain.JobQueued
+	job.CreatedAt = time.Now()
+	entry := &jobEntry{job: job, changed: make(chan struct{})}
+	s.jobs[entry.job.ID] = entry
+	s.mu.Unlock()
+
+	go s.run(entry, fn)
+	return job, nil
+}
+
+// ValidateCallbackURL comprueba que se pueda notificar a callbackURL al terminar un trabajo.
+// Una URL vacía es válida: el trabajo no tendrá notificación.
+func (s *JobService) ValidateCallbackURL(ctx context.Context, callbackURL string) error {
+	if callbackURL == "" {
+		return nil
+	}
+	return s.notifier.ValidateURL(ctx, callbackURL)
+}
+
+// Get devuelve el estado actual del trabajo id de owner
+func (s *JobService) Get(id, owner string) (domain.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.lookup(id, owner)
+	if err != nil {
+		return domain.Job{}, err
+	}
+	return entry.job, nil
+}
+
+// lookup busca el trabajo id. Un trabajo de otro owner se trata como inexistente, para no
+// revelar los IDs de otras API keys. Requiere el mutex.
+func (s *JobService) lookup(id, owner string) (*jobEntry, error) {
+	s.purgeExpired()
+	entry, ok := s.jobs[id]
+	if !ok || entry.job.Owner != owner {
+		return nil, domain.ErrJobNotFound
+	}
+	return entry, nil
+}
+
+// OpenResult abre el archivo generado por el trabajo terminado id de owner. Quien llama
+// debe cerrarlo.
+func (s *JobService) OpenResult(ctx context.Context, id, owner string) (io.ReadCloser, *domain.JobResult, error) {
+	result, err := s.result(id, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, _, err := s.storage.Get(ctx, result.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error leyendo el resultado del trabajo %s: %w", id, err)
+	}
+	return reader, result, nil
+}
+
+// result devuelve los datos del resultado de un trabajo terminado
+func (s *JobService) result(id, owner string) (*domain.JobResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.lookup(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	switch entry.job.Status {
+	case domain.JobCompleted:
+		return entry.result, nil
+	case domain.JobFailed:
+		return nil, fmt.Errorf("%w: %s", domain.ErrJobFailed, entry.job.Error)
+	default:
+		return nil, domain.ErrJobNotFinished
+	}
+}
+
+// JobResultPath devuelve la ruta de descarga del resultado de un trabajo
+func JobResultPath(id string) string {
+	return "/jobs/" + id + "/result"
+}
+
+// run ejecuta el trabajo, desligado de la petición que lo creó
+func (s *JobService) run(entry *jobEntry, fn JobFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	s.update(entry, func(job *domain.Job) {
+		now := time.Now()
+		job.Status = domain.JobRunning
+		job.StartedAt = &now
+	})
+
+	result, err := fn(ctx, func(event domain.JobEvent) {
+		s.update(entry, func(job *domain.Job) {
+			switch event.Type {
+			case domain.JobEventImageCompleted:
+				job.Succeeded++
+			case domain.JobEventImageFailed:
+				job.Failed++
+			}
+			job.Processed = job.Succeeded + job.Failed
+			if job.Total > 0 {
+				job.Progress = job.Processed * 100 / job.Total
+			}
+			entry.addEvent(event)
+		})
+	})
+	if err == nil {
+		err = s.store(ctx, entry.job.ID, result)
+	}
+
+	var job domain.Job
+	s.update(entry, func(j *domain.Job) {
+		s.active--
+		now := time.Now()
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status = domain.JobFailed
+			j.Error = err.Error()
+		} else {
+			j.Status = domain.JobCompleted
+			j.Progress = 100
+			entry.result = result
+		}
+		job = *j
+
+		final := job
+		event := domain.JobEvent{Type: domain.JobEventFailed, Job: &final}
+		if err == nil {
+			event.Type = domain.JobEventCompleted
+			final.ResultURL = JobResultPath(job.ID)
+		}
+		entry.addEvent(event)
+	})
+
+	if err != nil {
+		slog.Warn("Trabajo fallido", "job_id", job.ID, "error", err)
+	} else {
+		slog.Info("Trabajo completado", "job_id", job.ID, "images", job.Total)
+	}
+	if job.CallbackURL != "" && s.notifier != nil {
+		s.notify(job, result)
+	}
+}
+
+// store guarda el archivo del resultado en el almacenamiento y libera la copia en memoria
+func (s *JobService) store(ctx context.Context, id string, result *domain.JobResult) error {
+	object, err := s.storage.Put(ctx, jobResultKey(id, result.Filename), result.Data, result.ContentType)
+	if err != nil {
+		return fmt.Errorf("error guardando el resultado: %w", err)
+	}
+	result.Key = object.Key
+	result.Size = object.Size
+	result.ETag = object.ETag
+	result.Data = nil
+	return nil
+}
+
+// jobResultKey devuelve la clave de almacenamiento del resultado de un trabajo
+func jobResultKey(id, filename string) string {
+	return "jobs/" + id + "/" + filename
+}
+
+// notify envía la notificación webhook de un trabajo terminado
+func (s *JobService) notify(job domain.Job, result *domain.JobResult) {
+	notification := &domain.JobNotification{Event: domain.JobEventFailed}
+	if job.Status == domain.JobCompleted {
+		notification.Event = domain.JobEventCompleted
+		job.ResultURL = s.publicURL + JobResultPath(job.ID)
+	}
+	if result != nil && result.Manifest != nil {
+		notification.Images = result.Manifest.Images
+	}
+	notification.Job = job
+
+	// El trabajo ya terminó, así que los reintentos no bloquean a nadie; el notificador
+	// limita su duración total
+	if err := s.notifier.Notify(context.Background(), job.CallbackURL, notification); err != nil {
+		slog.Warn("Notificación del trabajo fallida", "job_id", job.ID, "callback_url", job.CallbackURL, "error", err)
+	}
+}
+
+// Events devuelve los eventos del trabajo id de owner a partir de la posición from (0 es el
+// primero), si el trabajo ya terminó y un canal que se cierra cuando se agregan eventos nuevos.
+// Cuando finished es true no habrá más eventos después de los devueltos.
+func (s *JobService) Events(id, owner string, from int) (events []domain.JobEvent, finished bool, changed <-chan struct{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.lookup(id, owner)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if from < len(entry.events) {
+		events = append(events, entry.events[from:]...)
+	}
+	finished = entry.job.FinishedAt != nil
+	return events, finished, entry.changed, nil
+}
+
+// WriteEvents envía por w como Server-Sent Events los eventos del trabajo id de owner que
+// siguen a lastEventID, el ID del último evento recibido por el cliente (0 si ninguno). El ID
+// de cada evento es su posición, empezando en 1, así que el cliente puede reanudar con el
+// header Last-Event-ID. Mientras no hay eventos envía un comentario cada heartbeat. Termina
+// cuando el trabajo termina o desaparece, o cuando se cancela ctx. Si el trabajo no existe
+// devuelve el error sin escribir nada en w.
+func (s *JobService) WriteEvents(ctx context.Context, w http.ResponseWriter, id, owner string, lastEventID int, heartbeat time.Duration) error {
+	next := lastEventID
+	events, finished, changed, err := s.Events(id, owner, next)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		for _, event := range events {
+			next++
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", next, event.Type, data)
+		}
+		if err := controller.Flush(); err != nil || finished {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			// Comentario para que los proxies no cierren la conexión inactiva
+			fmt.Fprint(w, ": ping\n\n")
+		case <-ctx.Done():
+			return nil
+		}
+
+		events, finished, changed, err = s.Events(id, owner, next)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// addEvent agrega un evento con los contadores actuales y avisa a quienes esperan. Requiere el mutex.
+func (e *jobEntry) addEvent(event domain.JobEvent) {
+	event.Processed = e.job.Processed
+	event.Total = e.job.Total
+	e.events = append(e.events, event)
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// update modifica el estado de un trabajo bajo el mutex
+func (s *JobService) update(entry *jobEntry, fn func(job *domain.Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&entry.job)
+}
+
+// ExpiresAt devuelve cuándo se elimina job con su resultado, o el instante cero si aún no
+// ha terminado
+func (s *JobService) ExpiresAt(job domain.Job) time.Time {
+	if job.FinishedAt == nil {
+		return time.Time{}
+	}
+	return job.FinishedAt.Add(s.ttl)
+}
+
+// purgeExpired elimina los trabajos terminados hace más de ttl y sus resultados. Requiere el mutex.
+func (s *JobService) purgeExpired() {
+	cutoff := time.Now().Add(-s.ttl)
+	var keys []string
+	for id, entry := range s.jobs {
+		if entry.job.FinishedAt != nil && entry.job.FinishedAt.Before(cutoff) {
+			if entry.result != nil && entry.result.Key != "" {
+				keys = append(keys, entry.result.Key)
+			}
+			delete(s.jobs, id)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	// Borrar fuera del mutex: en S3 cada borrado es una petición de red
+	go func() {
+		for _, key := range keys {
+			if err := s.storage.Delete(context.Background(), key); err != nil {
+				slog.Warn("Error eliminando resultado expirado", "key", key, "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// waitFinished espera a que termine el trabajo id de owner
+func waitFinished(t *testing.T, jobs *JobService, id, owner string) domain.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := jobs.Get(id, owner)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("el trabajo %s no terminó", id)
+	return domain.Job{}
+}
+
+func TestJobServiceOwnerIsolation(t *testing.T) {
+	jobs := NewJobService(NewMemoryStorage(), time.Minute, time.Hour, 0, NewWebhookNotifier("", 0, time.Millisecond, time.Second, false), "http://localhost")
+	job, err := jobs.Start(domain.Job{Total: 1, Owner: "web"}, func(ctx context.Context, report func(domain.JobEvent)) (*domain.JobResult, error) {
+		report(domain.JobEvent{Type: domain.JobEventImageCompleted})
+		return &domain.JobResult{
+			Data:        []byte("zip"),
+			ContentType: "application/zip",
+			Filename:    "resultado.zip",
+			Manifest:    &domain.BatchManifest{},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if job := waitFinished(t, jobs, job.ID, "web"); job.Status != domain.JobCompleted {
+		t.Fatalf("estado %s, error %q", job.Status, job.Error)
+	}
+
+	// El dueño accede al trabajo
+	reader, _, err := jobs.OpenResult(context.Background(), job.ID, "web")
+	if err != nil {
+		t.Fatalf("OpenResult del dueño: %v", err)
+	}
+	reader.Close()
+	if events, _, _, err := jobs.Events(job.ID, "web", 0); err != nil || len(events) == 0 {
+		t.Fatalf("Events del dueño = %v, %v", events, err)
+	}
+
+	// Otra clave, o ninguna, no distingue el trabajo de uno inexistente
+	for _, owner := range []string{"otra", ""} {
+		if _, err := jobs.Get(job.ID, owner); !errors.Is(err, domain.ErrJobNotFound) {
+			t.Errorf("Get(%q) = %v, se esperaba ErrJobNotFound", owner, err)
+		}
+		if _, _, err := jobs.OpenResult(context.Background(), job.ID, owner); !errors.Is(err, domain.ErrJobNotFound) {
+			t.Errorf("OpenResult(%q) = %v, se esperaba ErrJobNotFound", owner, err)
+		}
+		if _, _, _, err := jobs.Events(job.ID, owner, 0); !errors.Is(err, domain.ErrJobNotFound) {
+			t.Errorf("Events(%q) = %v, se esperaba ErrJobNotFound", owner, err)
+		}
+	}
+}
+
+func TestJobServiceMaxActive(t *testing.T) {
+	jobs := NewJobService(NewMemoryStorage(), time.Minute, time.Hour, 2, nil, "http://localhost")
+
+	// Los trabajos quedan en ejecución hasta que se cierra su canal
+	start := func(release <-chan struct{}) (domain.Job, error) {
+		return jobs.Start(domain.Job{Total: 1}, func(ctx context.Context, report func(domain.JobEvent)) (*domain.JobResult, error) {
+			<-release
+			return nil, errors.New("cancelado")
+		})
+	}
+	releases := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	var started []domain.Job
+	for _, release := range releases {
+		job, err := start(release)
+		if err != nil {
+			t.Fatalf("Start con %d trabajos en curso: %v", len(started), err)
+		}
+		started = append(started, job)
+	}
+
+	blocked := make(chan struct{})
+	defer close(blocked)
+	if _, err := start(blocked); !errors.Is(err, domain.ErrTooManyJobs) {
+		t.Fatalf("Start con 2 trabajos en curso = %v, se esperaba ErrTooManyJobs", err)
+	}
+
+	// Al terminar un trabajo, con éxito o con error, se libera su hueco
+	close(releases[0])
+	waitFinished(t, jobs, started[0].ID, "")
+	job, err := start(releases[1])
+	if err != nil {
+		t.Fatalf("Start tras terminar un trabajo: %v", err)
+	}
+	close(releases[1])
+	waitFinished(t, jobs, started[1].ID, "")
+	waitFinished(t, jobs, job.ID, "")
+}
+
+// sseMessage es un mensaje de un stream Server-Sent Events: un evento o un comentario
+type sseMessage struct {
+	id, event, data string
+	comment         string
+}
+
+// readSSE lee el siguiente mensaje del stream, o devuelve false si el stream terminó
+func readSSE(t *testing.T, reader *bufio.Reader) (sseMessage, bool) {
+	t.Helper()
+	var message sseMessage
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return message, false
+		}
+		if err != nil {
+			t.Fatalf("leyendo el stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return message, true
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "":
+			message.comment = value
+		case "id":
+			message.id = value
+		case "event":
+			message.event = value
+		case "data":
+			message.data = value
+		}
+	}
+}
+
+// eventsServer sirve los eventos de jobs con un heartbeat de heartbeat, leyendo el último
+// evento recibido del header Last-Event-ID como el handler de la API
+func eventsServer(jobs *JobService, heartbeat time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+		if err := jobs.WriteEvents(r.Context(), w, strings.TrimPrefix(r.URL.Path, "/"), "web", lastEventID, heartbeat); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	}))
+}
+
+// getEvents conecta al stream del trabajo id, reanudando tras lastEventID si no está vacío
+func getEvents(t *testing.T, server *httptest.Server, id, lastEventID string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func TestJobServiceWriteEvents(t *testing.T) {
+	jobs := NewJobService(NewMemoryStorage(), time.Minute, time.Hour, 0, nil, "http://localhost")
+	server := eventsServer(jobs, 20*time.Millisecond)
+	defer server.Close()
+
+	// El trabajo informa de los eventos que recibe por steps y termina al cerrarse
+	steps := make(chan domain.JobEvent)
+	job, err := jobs.Start(domain.Job{Total: 1, Owner: "web"}, func(ctx context.Context, report func(domain.JobEvent)) (*domain.JobResult, error) {
+		for event := range steps {
+			report(event)
+		}
+		return &domain.JobResult{Data: []byte("zip"), ContentType: "application/zip", Filename: "resultado.zip", Manifest: &domain.BatchManifest{}}, nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	response := getEvents(t, server, job.ID, "")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("respuesta %d (%s), se esperaba un stream", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(response.Body)
+
+	// Sin eventos solo llegan los comentarios del heartbeat
+	if message, ok := readSSE(t, stream); !ok || message.comment != "ping" {
+		t.Fatalf("mensaje %+v, se esperaba el heartbeat", message)
+	}
+
+	steps <- domain.JobEvent{Type: domain.JobEventImageStarted, Index: 1}
+	steps <- domain.JobEvent{Type: domain.JobEventImageCompleted, Index: 1}
+	close(steps)
+
+	want := []struct{ id, event string }{
+		{"1", domain.JobEventImageStarted},
+		{"2", domain.JobEventImageCompleted},
+		{"3", domain.JobEventCompleted},
+	}
+	for _, want := range want {
+		message, ok := readSSE(t, stream)
+		for ok && message.comment != "" {
+			message, ok = readSSE(t, stream)
+		}
+		if !ok || message.id != want.id || message.event != want.event {
+			t.Fatalf("mensaje %+v, se esperaba el evento %s con id %s", message, want.event, want.id)
+		}
+		var event domain.JobEvent
+		if err := json.Unmarshal([]byte(message.data), &event); err != nil || event.Type != want.event {
+			t.Fatalf("datos del evento %s: %q (%v)", want.id, message.data, err)
+		}
+		if want.event == domain.JobEventCompleted && (event.Job == nil || event.Job.ResultURL != JobResultPath(job.ID)) {
+			t.Fatalf("el evento final no incluye el trabajo con su resultado: %s", message.data)
+		}
+	}
+	// El stream se cierra al terminar el trabajo
+	if message, ok := readSSE(t, stream); ok {
+		t.Fatalf("el stream siguió abierto tras el evento final: %+v", message)
+	}
+
+	// Al reanudar se reciben solo los eventos posteriores a Last-Event-ID
+	resumes := []struct {
+		lastEventID string
+		want        []string
+	}{
+		{lastEventID: "", want: []string{"1", "2", "3"}},
+		{lastEventID: "1", want: []string{"2", "3"}},
+		{lastEventID: "3", want: nil},
+		{lastEventID: "no es un número", want: []string{"1", "2", "3"}},
+	}
+	for _, resume := range resumes {
+		stream := bufio.NewReader(getEvents(t, server, job.ID, resume.lastEventID).Body)
+		var got []string
+		for message, ok := readSSE(t, stream); ok; message, ok = readSSE(t, stream) {
+			got = append(got, message.id)
+		}
+		if !slices.Equal(got, resume.want) {
+			t.Errorf("con Last-Event-ID %q se recibieron %v, se esperaba %v", resume.lastEventID, got, resume.want)
+		}
+	}
+}
+
+func TestJobServiceWriteEventsNotFound(t *testing.T) {
+	jobs := NewJobService(NewMemoryStorage(), time.Minute, 50*time.Millisecond, 0, nil, "http://localhost")
+	server := eventsServer(jobs, time.Minute)
+	defer server.Close()
+
+	job, err := jobs.Start(domain.Job{Total: 0, Owner: "web"}, func(ctx context.Context, report func(domain.JobEvent)) (*domain.JobResult, error) {
+		return &domain.JobResult{Data: []byte("zip"), ContentType: "application/zip", Filename: "resultado.zip", Manifest: &domain.BatchManifest{}}, nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitFinished(t, jobs, job.ID, "web")
+	if response := getEvents(t, server, job.ID, ""); response.StatusCode != http.StatusOK {
+		t.Fatalf("el trabajo terminado respondió %d", response.StatusCode)
+	}
+
+	// Pasado el TTL el trabajo se elimina, y con él sus eventos
+	time.Sleep(100 * time.Millisecond)
+	for _, id := range []string{job.ID, "no-existe"} {
+		response := getEvents(t, server, id, "1")
+		if response.StatusCode != http.StatusNotFound || response.Header.Get("Content-Type") == "text/event-stream" {
+			t.Errorf("el trabajo %s respondió %d (%s), se esperaba 404", id, response.StatusCode, response.Header.Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache es una caché LRU limitada por el tamaño total en bytes de sus valores.
+// Al superar maxBytes se descartan los valores usados hace más tiempo.
+type lruCache[V any] struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	order    *list.List // Más reciente al frente
+	items    map[string]*list.Element
+	onEvict  func(key string, value V) // Se llama fuera del mutex al descartar un valor; puede ser nil
+}
+
+// lruItem es un valor de la caché junto con su clave y su tamaño
+type lruItem[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// newLRUCache crea una caché vacía de como máximo maxBytes
+func newLRUCache[V any](maxBytes int64, onEvict func(key string, value V)) *lruCache[V] {
+	return &lruCache[V]{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		onEvict:  onEvict,
+	}
+}
+
+// get devuelve el valor de key y lo marca como usado recientemente
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruItem[V]).value, true
+}
+
+// add guarda value con la clave key y un tamaño de size bytes. Los valores más grandes
+// que la caché completa no se guardan. Devuelve false si no se guardó.
+func (c *lruCache[V]) add(key string, value V, size int64) bool {
+	if size > c.maxBytes {
+		return false
+	}
+
+	c.mu.Lock()
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*lruItem[V])
+		c.bytes += size - item.size
+		item.value = value
+		item.size = size
+		c.order.MoveToFront(element)
+	} else {
+		c.items[key] = c.order.PushFront(&lruItem[V]{key: key, value: value, size: size})
+		c.bytes += size
+	}
+
+	var evicted []*lruItem[V]
+	for c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		item := oldest.Value.(*lruItem[V])
+		c.order.Remove(oldest)
+		delete(c.items, item.key)
+		c.bytes -= item.size
+		evicted = append(evicted, item)
+	}
+	c.mu.Unlock()
+
+	if c.onEvict != nil {
+		for _, item := range evicted {
+			c.onEvict(item.key, item.value)
+		}
+	}
+	return true
+}
+
+// remove elimina key de la caché sin llamar a onEvict
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+		c.bytes -= element.Value.(*lruItem[V]).size
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	var evicted []string
+	cache := newLRUCache(300, func(key string, _ int) {
+		evicted = append(evicted, key)
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		if !cache.add(key, len(key), 100) {
+			t.Fatalf("add(%s) no guardó un valor que cabe en la caché", key)
+		}
+	}
+	// Leer a la marca como reciente: al superar el presupuesto se descarta b, no a
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("get(a) falló con a en la caché")
+	}
+	cache.add("d", 1, 150)
+
+	if want := []string{"b", "c"}; !slices.Equal(evicted, want) {
+		t.Fatalf("descartados = %v, se esperaba %v", evicted, want)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("get(%s) = %v, se esperaba %v", key, ok, want)
+		}
+	}
+	if cache.bytes != 250 {
+		t.Fatalf("bytes = %d, se esperaba 250", cache.bytes)
+	}
+}
+
+func TestLRUCacheSizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     map[string]int64 // Se agregan en orden alfabético
+		wantKeys  []string
+		wantBytes int64
+	}{
+		{name: "cabe justo", sizes: map[string]int64{"a": 60, "b": 40}, wantKeys: []string{"a", "b"}, wantBytes: 100},
+		{name: "mayor que la caché", sizes: map[string]int64{"a": 101}, wantBytes: 0},
+		{name: "el grande desplaza a los antiguos", sizes: map[string]int64{"a": 30, "b": 30, "c": 90}, wantKeys: []string{"c"}, wantBytes: 90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newLRUCache[string](100, nil)
+			keys := make([]string, 0, len(tt.sizes))
+			for key := range tt.sizes {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				cache.add(key, key, tt.sizes[key])
+			}
+
+			var got []string
+			for _, key := range keys {
+				if _, ok := cache.get(key); ok {
+					got = append(got, key)
+				}
+			}
+			if !slices.Equal(got, tt.wantKeys) || cache.bytes != tt.wantBytes {
+				t.Fatalf("claves %v con %d bytes, se esperaban %v con %d", got, cache.bytes, tt.wantKeys, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestLRUCacheReplaceAndRemove(t *testing.T) {
+	evictions := 0
+	cache := newLRUCache(100, func(string, string) { evictions++ })
+
+	cache.add("a", "viejo", 80)
+	cache.add("a", "nuevo", 30)
+	if value, ok := cache.get("a"); !ok || value != "nuevo" || cache.bytes != 30 {
+		t.Fatalf("get(a) = %q, %v con %d bytes; se esperaba el valor nuevo con 30 bytes", value, ok, cache.bytes)
+	}
+
+	cache.remove("a")
+	cache.remove("no-existe")
+	if _, ok := cache.get("a"); ok || cache.bytes != 0 {
+		t.Fatalf("a sigue en la caché tras remove (%d bytes)", cache.bytes)
+	}
+	if evictions != 0 {
+		t.Fatalf("onEvict se llamó %d veces; ni reemplazar ni remove descartan valores", evictions)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// MemoryStorage implementa domain.Storage en memoria. Es el almacenamiento por defecto;
+// los objetos se pierden al reiniciar el servidor.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// memoryObject es un objeto guardado junto con sus metadatos
+type memoryObject struct {
+	data []byte
+	info domain.StoredObject
+}
+
+// NewMemoryStorage crea un almacenamiento en memoria vacío
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string]memoryObject)}
+}
+
+// Put guarda una copia de data con la clave key
+func (s *MemoryStorage) Put(ctx context.Context, key string, data []byte, contentType string) (*domain.StoredObject, error) {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := domain.StoredObject{
+		Key:         key,
+		Size:        int64(len(data)),
+		ETag:        contentETag(data),
+		ContentType: contentType,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{data: bytes.Clone(data), info: info}
+	return &info, nil
+}
+
+// Get devuelve el contenido y los metadatos del objeto key
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, *domain.StoredObject, error) {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, nil, domain.ErrObjectNotFound
+	}
+	info := object.info
+	return io.NopCloser(bytes.NewReader(object.data)), &info, nil
+}
+
+// Delete elimina el objeto key; no es un error si no existe
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// contentETag calcula el ETag de un objeto como el MD5 de su contenido, igual que S3
+// para los objetos subidos en una sola parte
+func contentETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// sanitizeStorageKey valida una clave de almacenamiento con las mismas reglas que las rutas
+// de los archivos subidos, de modo que no pueda apuntar fuera del almacenamiento
+func sanitizeStorageKey(key string) (string, error) {
+	sanitized, err := sanitizePath(key)
+	if err != nil {
+		return "", domain.ErrInvalidStorageKey
+	}
+	return sanitized, nil
+}
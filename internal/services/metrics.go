@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace es el prefijo de todas las métricas de la API
+const metricsNamespace = "image_compress"
+
+// Metrics implementa domain.MetricsRecorder con métricas Prometheus y registra además
+// las peticiones HTTP. Usa un registro propio que se expone con Handler.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	images          *prometheus.CounterVec
+	inputBytes      prometheus.Counter
+	outputBytes     prometheus.Counter
+	ratio           prometheus.Histogram
+	decodeDuration  *prometheus.HistogramVec
+	encodeDuration  *prometheus.HistogramVec
+	batchSize       prometheus.Histogram
+	errors          *prometheus.CounterVec
+	cache           *prometheus.CounterVec
+}
+
+// NewMetrics crea y registra las métricas, junto con las del runtime de Go y del proceso
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Peticiones HTTP por ruta, método y código de estado.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duración de las peticiones HTTP por ruta y método.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"route", "method"}),
+		images: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "images_processed_total",
+			Help:      "Imágenes comprimidas por formato de entrada y de salida.",
+		}, []string{"input_format", "output_format"}),
+		inputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_input_bytes_total",
+			Help:      "Bytes de las imágenes recibidas para comprimir.",
+		}),
+		outputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_output_bytes_total",
+			Help:      "Bytes de las imágenes comprimidas devueltas.",
+		}),
+		ratio: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "compression_ratio",
+			Help:      "Relación tamaño de salida / tamaño de entrada de cada imagen.",
+			Buckets:   []float64{.05, .1, .2, .3, .4, .5, .6, .7, .8, .9, 1, 1.5, 2, 5},
+		}),
+		decodeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_decode_duration_seconds",
+			Help:      "Duración de la decodificación de las imágenes por formato de entrada.",
+			Buckets:   prometheus.ExponentialBuckets(.001, 2, 14),
+		}, []string{"format"}),
+		encodeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_encode_duration_seconds",
+			Help:      "Duración de la codificación de las imágenes por formato de salida.",
+			Buckets:   prometheus.ExponentialBuckets(.001, 2, 14),
+		}, []string{"format"}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_size",
+			Help:      "Número de imágenes por lote.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Imágenes rechazadas por código de error del dominio.",
+		}, []string{"code"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Consultas a la caché de resultados por resultado (hit, miss) y nivel que tenía el resultado.",
+		}, []string{"result", "tier"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.images, m.inputBytes, m.outputBytes,
+		m.ratio, m.decodeDuration, m.encodeDuration, m.batchSize, m.errors, m.cache,
+	)
+	return m
+}
+
+// Handler devuelve el handler HTTP que expone las métricas en formato Prometheus
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest registra una petición HTTP. route es el patrón de la ruta (por ejemplo
+// "/jobs/{id}") para no crear una serie por cada URL distinta.
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	m.requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// ObserveDecode registra la duración de una decodificación
+func (m *Metrics) ObserveDecode(format domain.ImageFormat, duration time.Duration) {
+	m.decodeDuration.WithLabelValues(string(format)).Observe(duration.Seconds())
+}
+
+// ObserveEncode registra la duración de una codificación
+func (m *Metrics) ObserveEncode(format domain.ImageFormat, duration time.Duration) {
+	m.encodeDuration.WithLabelValues(string(format)).Observe(duration.Seconds())
+}
+
+// ObserveImage registra una imagen comprimida
+func (m *Metrics) ObserveImage(input, output domain.ImageFormat, inputSize, outputSize int64) {
+	m.images.WithLabelValues(string(input), string(output)).Inc()
+	m.inputBytes.Add(float64(inputSize))
+	m.outputBytes.Add(float64(outputSize))
+	if inputSize > 0 {
+		m.ratio.Observe(float64(outputSize) / float64(inputSize))
+	}
+}
+
+// ObserveError registra un error con su código del dominio
+func (m *Metrics) ObserveError(err error) {
+	m.errors.WithLabelValues(domain.ErrorCode(err)).Inc()
+}
+
+// RecordImage no registra nada: el procesador mide cada imagen comprimida con sus formatos
+// (ObserveImage). Junto con RecordError permite usar Metrics como domain.StatsRecorder.
+func (m *Metrics) RecordImage(ctx context.Context, inputSize int, result *domain.CompressionResult) {}
+
+// RecordError registra el error de una imagen que un handler rechazó
+func (m *Metrics) RecordError(ctx context.Context, err error) {
+	m.ObserveError(err)
+}
+
+// ObserveBatch registra el tamaño de un lote
+func (m *Metrics) ObserveBatch(size int) {
+	m.batchSize.Observe(float64(size))
+}
+
+// ObserveCache registra una consulta a la caché. Los aciertos no pasan por el procesador,
+// así que no cuentan en images_processed_total.
+func (m *Metrics) ObserveCache(status domain.CacheStatus, tier string) {
+	m.cache.WithLabelValues(strings.ToLower(string(status)), tier).Inc()
+}
+
+// noopMetrics es el domain.MetricsRecorder de los servicios creados sin métricas
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveDecode(domain.ImageFormat, time.Duration)                   {}
+func (noopMetrics) ObserveEncode(domain.ImageFormat, time.Duration)                   {}
+func (noopMetrics) ObserveImage(domain.ImageFormat, domain.ImageFormat, int64, int64) {}
+func (noopMetrics) ObserveError(error)                                                {}
+func (noopMetrics) ObserveBatch(int)                                                  {}
+func (noopMetrics) ObserveCache(domain.CacheStatus, string)                           {}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// recordingMetrics es un domain.MetricsRecorder que guarda los errores, lotes y consultas
+// a la caché registrados. Las consultas se guardan como "<resultado>/<nivel>".
+type recordingMetrics struct {
+	noopMetrics
+	mu      sync.Mutex
+	errors  []error
+	batches []int
+	lookups []string
+}
+
+func (m *recordingMetrics) ObserveError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, err)
+}
+
+func (m *recordingMetrics) ObserveBatch(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, size)
+}
+
+func (m *recordingMetrics) ObserveCache(status domain.CacheStatus, tier string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups = append(m.lookups, string(status)+"/"+tier)
+}
+
+func TestImageProcessorDoesNotRecordErrors(t *testing.T) {
+	metrics := &recordingMetrics{}
+	processor := NewImageProcessorService(1<<20, metrics)
+	ctx := context.Background()
+
+	// Validar es una consulta: que una imagen no sea válida no significa que se rechazara
+	if err := processor.ValidateImage(ctx, []byte("no es una imagen")); !errors.Is(err, domain.ErrInvalidImageData) {
+		t.Fatalf("ValidateImage = %v, se esperaba ErrInvalidImageData", err)
+	}
+	if err := processor.ValidateImage(ctx, nil); !errors.Is(err, domain.ErrEmptyImageData) {
+		t.Fatalf("ValidateImage = %v, se esperaba ErrEmptyImageData", err)
+	}
+	if _, err := processor.CompressImage(ctx, []byte("no es una imagen"), 80, domain.JPEG, ""); !errors.Is(err, domain.ErrInvalidImageData) {
+		t.Fatalf("CompressImage = %v, se esperaba ErrInvalidImageData", err)
+	}
+	if len(metrics.errors) != 0 {
+		t.Fatalf("se registraron los errores %v, solo deben registrarlos los handlers", metrics.errors)
+	}
+}
+
+func TestMetricsRecordsRejectedImages(t *testing.T) {
+	metrics := NewMetrics()
+	var recorder domain.StatsRecorder = StatsRecorders{metrics}
+	recorder.RecordError(context.Background(), domain.ErrInvalidImageData)
+	recorder.RecordError(context.Background(), fmt.Errorf("%w: jpeg", domain.ErrInvalidImageData))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `image_compress_errors_total{code="invalid_image_data"} 2`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("las métricas no contienen %s", want)
+	}
+}
+
+func TestMetricsRecordsCacheLookups(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObserveCache(domain.CacheHit, "memory")
+	metrics.ObserveCache(domain.CacheHit, "memory")
+	metrics.ObserveCache(domain.CacheMiss, "none")
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`image_compress_cache_lookups_total{result="hit",tier="memory"} 2`,
+		`image_compress_cache_lookups_total{result="miss",tier="none"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("las métricas no contienen %s", want)
+		}
+	}
+}
+
+func TestOrderedBatchRecordsSize(t *testing.T) {
+	errFailed := errors.New("la imagen falló")
+
+	tests := []struct {
+		name    string
+		failAt  int // Índice de la tarea cuyo resultado detiene el lote, -1 ninguno
+		stopAt  int // Tareas enviadas antes de abandonar el lote sin Wait, -1 se llama a Wait
+		want    int
+		wantErr error
+	}{
+		{name: "lote completo", failAt: -1, stopAt: -1, want: 5},
+		{name: "lote detenido por un fallo", failAt: 0, stopAt: -1, wantErr: errFailed},
+		{name: "lote abandonado antes de Wait", failAt: -1, stopAt: 3, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := &recordingMetrics{}
+			batch := NewOrderedBatch(context.Background(), NewWorkerPool(1, nil, metrics), func(result int, err error) error {
+				if result == tt.failAt {
+					return errFailed
+				}
+				return nil
+			})
+			func() {
+				defer batch.Stop()
+				for i := 0; i < 5; i++ {
+					if i == tt.stopAt {
+						return
+					}
+					if err := batch.Submit(func(ctx context.Context) int { return i }); err != nil {
+						return
+					}
+				}
+				if err := batch.Wait(); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Wait = %v, se esperaba %v", err, tt.wantErr)
+				}
+			}()
+
+			if len(metrics.batches) != 1 {
+				t.Fatalf("el lote se registró %d veces, se esperaba 1", len(metrics.batches))
+			}
+			if tt.wantErr == nil && metrics.batches[0] != tt.want {
+				t.Fatalf("tamaño registrado %d, se esperaba %d", metrics.batches[0], tt.want)
+			}
+		})
+	}
+}
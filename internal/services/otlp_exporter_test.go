@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector es un receptor OTLP/HTTP falso que guarda las peticiones de exportación
+type otlpCollector struct {
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	requests []*coltracepb.ExportTraceServiceRequest
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.headers = append(c.headers, r.Header.Clone())
+	c.requests = append(c.requests, &request)
+	c.mu.Unlock()
+
+	response, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(response)
+}
+
+// spans devuelve los spans recibidos por nombre y el nombre del servicio que los envió
+func (c *otlpCollector) spans(t *testing.T) (map[string]*tracepb.Span, string) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	spans := make(map[string]*tracepb.Span)
+	var service string
+	for _, request := range c.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, attr := range resourceSpans.Resource.GetAttributes() {
+				if attr.Key == "service.name" {
+					service = attr.Value.GetStringValue()
+				}
+			}
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				if scopeSpans.Scope.GetName() != tracerName {
+					t.Errorf("spans del scope %q, se esperaba %q", scopeSpans.Scope.GetName(), tracerName)
+				}
+				for _, span := range scopeSpans.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans, service
+}
+
+func TestOTLPTracerProviderExportsSpans(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer secreto,x-tenant=pruebas")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+
+	ctx := context.Background()
+	provider, err := NewOTLPTracerProvider(ctx, "image-compress-test")
+	if err != nil {
+		t.Fatalf("NewOTLPTracerProvider: %v", err)
+	}
+
+	// tracer usa el TracerProvider global, que otras pruebas fijan una vez por proceso
+	providerTracer := provider.Tracer(tracerName)
+	requestCtx, request := providerTracer.Start(ctx, "POST /compress")
+	compressCtx, compress := providerTracer.Start(requestCtx, "image.compress")
+	_, decode := providerTracer.Start(compressCtx, "image.decode")
+	decode.End()
+	compress.End()
+	request.End()
+
+	// Los spans van en lotes: Shutdown debe enviar los pendientes antes de volver
+	if err := provider.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	spans, service := collector.spans(t)
+	if len(spans) != 3 {
+		t.Fatalf("se recibieron los spans %v, se esperaban 3", spans)
+	}
+	if service != "image-compress-test" {
+		t.Errorf("service.name = %q, se esperaba image-compress-test", service)
+	}
+
+	parents := map[string]string{"image.compress": "POST /compress", "image.decode": "image.compress"}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no se recibió %s", name)
+		}
+		if string(span.ParentSpanId) != string(spans[parent].SpanId) {
+			t.Errorf("%s no es hijo de %s", name, parent)
+		}
+		if string(span.TraceId) != string(spans["POST /compress"].TraceId) {
+			t.Errorf("%s no pertenece a la traza de la petición", name)
+		}
+	}
+	if len(spans["POST /compress"].ParentSpanId) != 0 {
+		t.Errorf("el span de la petición tiene padre")
+	}
+
+	collector.mu.Lock()
+	for i, path := range collector.paths {
+		if path != "/v1/traces" {
+			t.Errorf("exportación a %s, se esperaba /v1/traces", path)
+		}
+		header := collector.headers[i]
+		if header.Get("Authorization") != "Bearer secreto" || header.Get("X-Tenant") != "pruebas" {
+			t.Errorf("la exportación no lleva los headers de OTEL_EXPORTER_OTLP_HEADERS: %v", header)
+		}
+		if header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Content-Type = %q, se esperaba application/x-protobuf", header.Get("Content-Type"))
+		}
+	}
+	exports := len(collector.requests)
+	collector.mu.Unlock()
+
+	// Después de Shutdown no se exporta nada más
+	_, late := providerTracer.Start(ctx, "tarde")
+	late.End()
+	if err := provider.ForceFlush(ctx); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.requests) != exports {
+		t.Fatalf("se exportaron spans después de Shutdown")
+	}
+}
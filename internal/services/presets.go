@@ -0,0 +1,151 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// PresetStore resuelve los presets configurados y la política de caché de sus resultados
+type PresetStore struct {
+	presets      map[string]domain.Preset
+	cacheControl string // Cache-Control de las peticiones sin preset o de presets que no lo definen
+}
+
+// NewPresetStore crea el almacén con presets. Cada preset debe tener un nombre único, una
+// calidad entre 1 y 100 si la define y un formato soportado.
+func NewPresetStore(presets []domain.Preset, cacheControl string) (*PresetStore, error) {
+	store := &PresetStore{presets: make(map[string]domain.Preset, len(presets)), cacheControl: cacheControl}
+	for i, preset := range presets {
+		if preset.Name == "" {
+			return nil, fmt.Errorf("el preset %d no tiene nombre", i+1)
+		}
+		if _, ok := store.presets[preset.Name]; ok {
+			return nil, fmt.Errorf("preset duplicado: %s", preset.Name)
+		}
+		if preset.Quality < 0 || preset.Quality > 100 {
+			return nil, fmt.Errorf("calidad %d inválida en el preset %s", preset.Quality, preset.Name)
+		}
+		switch preset.Format {
+		case "", domain.JPEG, domain.PNG, domain.WEBP:
+		default:
+			return nil, fmt.Errorf("formato %q no soportado en el preset %s", preset.Format, preset.Name)
+		}
+		store.presets[preset.Name] = preset
+	}
+	return store, nil
+}
+
+// LoadPresets lee los presets del archivo JSON path (domain.PresetsConfig). Un path vacío
+// no define ningún preset.
+func LoadPresets(path string) ([]domain.Preset, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo el archivo de presets: %w", err)
+	}
+	var config domain.PresetsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error decodificando el archivo de presets: %w", err)
+	}
+	return config.Presets, nil
+}
+
+// Get devuelve el preset name. Un nombre vacío devuelve un preset sin opciones.
+func (s *PresetStore) Get(name string) (domain.Preset, error) {
+	if name == "" {
+		return domain.Preset{}, nil
+	}
+	preset, ok := s.presets[name]
+	if !ok {
+		return domain.Preset{}, fmt.Errorf("%w: %s", domain.ErrUnknownPreset, name)
+	}
+	return preset, nil
+}
+
+// CacheControl devuelve el header Cache-Control de los resultados del preset name
+func (s *PresetStore) CacheControl(name string) string {
+	if preset, ok := s.presets[name]; ok && preset.CacheControl != "" {
+		return preset.CacheControl
+	}
+	return s.cacheControl
+}
+
+// PrivateCacheControl adapta policy a una respuesta autenticada: solo la caché del cliente
+// puede guardarla, así que public pasa a private y se quita s-maxage, que solo aplica a
+// cachés compartidas. Una política vacía o con no-store se devuelve sin cambios.
+func PrivateCacheControl(policy string) string {
+	directives := cacheDirectives(policy)
+	if len(directives) == 0 || hasDirective(directives, "no-store") {
+		return policy
+	}
+	result := []string{"private"}
+	for _, directive := range directives {
+		name, _, _ := strings.Cut(directive, "=")
+		switch strings.ToLower(name) {
+		case "public", "private", "s-maxage":
+			continue
+		}
+		result = append(result, directive)
+	}
+	return strings.Join(result, ", ")
+}
+
+// CapMaxAge limita el max-age de policy a maxAge, para que un resultado no siga en caché
+// después de eliminarse; si policy no define max-age se añade. Una política vacía o con
+// no-store se devuelve sin cambios.
+func CapMaxAge(policy string, maxAge time.Duration) string {
+	directives := cacheDirectives(policy)
+	if len(directives) == 0 || hasDirective(directives, "no-store") {
+		return policy
+	}
+	limit := max(int64(maxAge/time.Second), 0)
+	found := false
+	for i, directive := range directives {
+		name, value, _ := strings.Cut(directive, "=")
+		switch strings.ToLower(name) {
+		case "max-age", "s-maxage":
+			if seconds, err := strconv.ParseInt(value, 10, 64); err != nil || seconds > limit {
+				directives[i] = name + "=" + strconv.FormatInt(limit, 10)
+			}
+			found = found || strings.EqualFold(name, "max-age")
+		}
+	}
+	if !found {
+		directives = append(directives, "max-age="+strconv.FormatInt(limit, 10))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// cacheDirectives separa las directivas de un header Cache-Control
+func cacheDirectives(policy string) []string {
+	var directives []string
+	for _, directive := range strings.Split(policy, ",") {
+		if directive = strings.TrimSpace(directive); directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	return directives
+}
+
+// hasDirective indica si directives incluye la directiva name, sin distinguir mayúsculas
+func hasDirective(directives []string, name string) bool {
+	for _, directive := range directives {
+		if strings.EqualFold(directive, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len devuelve el número de presets configurados
+func (s *PresetStore) Len() int {
+	return len(s.presets)
+}
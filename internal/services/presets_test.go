@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+func TestNewPresetStoreValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		presets []domain.Preset
+		wantErr bool
+	}{
+		{name: "válidos", presets: []domain.Preset{{Name: "a", Quality: 60, Format: domain.WEBP}, {Name: "b"}}},
+		{name: "sin nombre", presets: []domain.Preset{{Quality: 60}}, wantErr: true},
+		{name: "duplicado", presets: []domain.Preset{{Name: "a"}, {Name: "a"}}, wantErr: true},
+		{name: "calidad fuera de rango", presets: []domain.Preset{{Name: "a", Quality: 101}}, wantErr: true},
+		{name: "formato no soportado", presets: []domain.Preset{{Name: "a", Format: "gif"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPresetStore(tt.presets, "")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("NewPresetStore = %v, se esperaba error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPresetStoreCacheControl(t *testing.T) {
+	store, err := NewPresetStore([]domain.Preset{
+		{Name: "miniatura", Quality: 60, CacheControl: "public, max-age=31536000, immutable"},
+		{Name: "sin-cache-control", Quality: 90},
+	}, "public, max-age=86400")
+	if err != nil {
+		t.Fatalf("NewPresetStore: %v", err)
+	}
+
+	tests := []struct {
+		preset string
+		want   string
+	}{
+		{preset: "miniatura", want: "public, max-age=31536000, immutable"},
+		{preset: "sin-cache-control", want: "public, max-age=86400"},
+		{preset: "", want: "public, max-age=86400"},
+	}
+	for _, tt := range tests {
+		if got := store.CacheControl(tt.preset); got != tt.want {
+			t.Errorf("CacheControl(%q) = %q, se esperaba %q", tt.preset, got, tt.want)
+		}
+	}
+
+	if preset, err := store.Get("miniatura"); err != nil || preset.Quality != 60 {
+		t.Fatalf("Get(miniatura) = %+v, %v", preset, err)
+	}
+	if preset, err := store.Get(""); err != nil || preset != (domain.Preset{}) {
+		t.Fatalf("Get(\"\") = %+v, %v; se esperaba un preset vacío", preset, err)
+	}
+	if _, err := store.Get("otro"); !errors.Is(err, domain.ErrUnknownPreset) {
+		t.Fatalf("Get(otro) = %v, se esperaba ErrUnknownPreset", err)
+	}
+}
+
+func TestPrivateCacheControl(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{policy: "public, max-age=86400", want: "private, max-age=86400"},
+		{policy: "public, max-age=31536000, s-maxage=600, immutable", want: "private, max-age=31536000, immutable"},
+		{policy: "max-age=60", want: "private, max-age=60"},
+		{policy: "private, max-age=60", want: "private, max-age=60"},
+		{policy: "no-store", want: "no-store"},
+		{policy: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := PrivateCacheControl(tt.policy); got != tt.want {
+			t.Errorf("PrivateCacheControl(%q) = %q, se esperaba %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestCapMaxAge(t *testing.T) {
+	tests := []struct {
+		policy string
+		maxAge time.Duration
+		want   string
+	}{
+		{policy: "public, max-age=86400", maxAge: time.Hour, want: "public, max-age=3600"},
+		{policy: "private, max-age=60", maxAge: time.Hour, want: "private, max-age=60"},
+		{policy: "public, max-age=86400, s-maxage=86400", maxAge: time.Hour, want: "public, max-age=3600, s-maxage=3600"},
+		{policy: "public", maxAge: 90 * time.Second, want: "public, max-age=90"},
+		{policy: "private, max-age=60", maxAge: -time.Second, want: "private, max-age=0"},
+		{policy: "no-store", maxAge: time.Hour, want: "no-store"},
+		{policy: "", maxAge: time.Hour, want: ""},
+	}
+	for _, tt := range tests {
+		if got := CapMaxAge(tt.policy, tt.maxAge); got != tt.want {
+			t.Errorf("CapMaxAge(%q, %v) = %q, se esperaba %q", tt.policy, tt.maxAge, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// purgeInterval es cada cuánto se eliminan los buckets inactivos
+const purgeInterval = time.Minute
+
+// RateLimiter limita las peticiones de cada cliente con un token bucket en memoria. Cada
+// petición consume un token y los tokens se reponen a razón de Rate por segundo hasta Burst.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+	now       func() time.Time // Reloj, reemplazable en las pruebas
+}
+
+// tokenBucket son los tokens de un cliente en el instante last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // Cuándo vuelve a estar lleno si no recibe peticiones
+}
+
+// NewRateLimiter crea el limitador sin buckets
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastPurge: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow consume un token del bucket de client con los límites de limit. Devuelve el estado
+// del bucket y false si no quedaban tokens. Un límite con Rate en cero siempre permite.
+func (l *RateLimiter) Allow(client string, limit domain.RateLimit) (domain.RateLimitStatus, bool) {
+	if limit.Rate <= 0 {
+		return domain.RateLimitStatus{}, true
+	}
+	burst := float64(max(limit.Burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.purgeIdle(now)
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[client] = bucket
+	}
+	// Si la clave cambió de límites el bucket se ajusta a la nueva ráfaga
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	status := domain.RateLimitStatus{Limit: int(burst)}
+	if allowed {
+		bucket.tokens--
+	} else {
+		status.RetryAfter = secondsToDuration((1 - bucket.tokens) / limit.Rate)
+	}
+	status.Remaining = int(bucket.tokens)
+	status.Reset = secondsToDuration((burst - bucket.tokens) / limit.Rate)
+	bucket.full = now.Add(status.Reset)
+	return status, allowed
+}
+
+// purgeIdle elimina, como mucho una vez por purgeInterval, los buckets que ya se llenaron:
+// equivalen a un cliente nuevo. Requiere l.mu.
+func (l *RateLimiter) purgeIdle(now time.Time) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+	l.lastPurge = now
+	for client, bucket := range l.buckets {
+		if now.After(bucket.full) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// QuotaTracker acumula en memoria el consumo diario (UTC) de cada cliente: imágenes
+// comprimidas y sus bytes de entrada. Implementa domain.StatsRecorder para contar las mismas
+// imágenes que las estadísticas de uso; el cliente se lee de domain.ClientFromContext.
+type QuotaTracker struct {
+	mu       sync.Mutex
+	day      time.Time // Inicio del día en curso
+	usage    map[string]*domain.QuotaUsage
+	reserved map[string]*domain.QuotaUsage // Apartado por las peticiones en curso (QuotaReservation)
+	now      func() time.Time              // Reloj, reemplazable en las pruebas
+}
+
+// NewQuotaTracker crea el contador sin consumo
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		day:      startOfDay(time.Now()),
+		usage:    make(map[string]*domain.QuotaUsage),
+		reserved: make(map[string]*domain.QuotaUsage),
+		now:      time.Now,
+	}
+}
+
+// Usage devuelve el consumo de client en el día en curso
+func (t *QuotaTracker) Usage(client string) domain.QuotaUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover()
+	usage := domain.QuotaUsage{Reset: t.day.AddDate(0, 0, 1)}
+	if current, ok := t.usage[client]; ok {
+		usage.Images, usage.Bytes = current.Images, current.Bytes
+	}
+	return usage
+}
+
+// RecordImage suma una imagen comprimida de inputSize bytes al cliente de ctx
+func (t *QuotaTracker) RecordImage(ctx context.Context, inputSize int, result *domain.CompressionResult) {
+	client := domain.ClientFromContext(ctx)
+	if client == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover()
+	usage, ok := t.usage[client]
+	if !ok {
+		usage = &domain.QuotaUsage{}
+		t.usage[client] = usage
+	}
+	usage.Images++
+	usage.Bytes += int64(inputSize)
+}
+
+// RecordError no consume cuota: las imágenes que fallan no cuentan
+func (t *QuotaTracker) RecordError(ctx context.Context, err error) {}
+
+// rollover reinicia el consumo al cambiar de día. Requiere t.mu.
+func (t *QuotaTracker) rollover() {
+	if today := startOfDay(t.now()); today.After(t.day) {
+		t.day = today
+		t.usage = make(map[string]*domain.QuotaUsage)
+		t.reserved = make(map[string]*domain.QuotaUsage)
+	}
+}
+
+// QuotaReservation es la parte de la cuota diaria de un cliente apartada por una petición
+// antes de comprimir sus imágenes, para que varias peticiones a la vez (o varios trabajos en
+// segundo plano) no superen juntas la cuota. Las imágenes comprimidas se cuentan igualmente
+// en RecordImage; Release devuelve lo apartado al terminar. Una reserva nil no limita nada.
+type QuotaReservation struct {
+	tracker *QuotaTracker
+	client  string
+	quota   domain.Quota
+	day     time.Time // Día de lo apartado; al cambiar de día la reserva se pierde
+	images  int64
+	bytes   int64
+}
+
+// Reserve crea una reserva vacía de la cuota quota de client. Devuelve nil si quota no limita nada.
+func (t *QuotaTracker) Reserve(client string, quota domain.Quota) *QuotaReservation {
+	if quota.Images <= 0 && quota.Bytes <= 0 {
+		return nil
+	}
+	return &QuotaReservation{tracker: t, client: client, quota: quota}
+}
+
+// Add aparta images imágenes y bytes bytes de entrada más. Devuelve domain.ErrQuotaExceeded,
+// sin apartar nada, si junto con lo ya consumido y apartado por el cliente superan la cuota.
+func (r *QuotaReservation) Add(images int, bytes int64) error {
+	if r == nil {
+		return nil
+	}
+	t := r.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover()
+	if !r.day.Equal(t.day) {
+		r.day, r.images, r.bytes = t.day, 0, 0
+	}
+	var used domain.QuotaUsage
+	if usage, ok := t.usage[r.client]; ok {
+		used.Images, used.Bytes = usage.Images, usage.Bytes
+	}
+	reserved, ok := t.reserved[r.client]
+	if ok {
+		used.Images += reserved.Images
+		used.Bytes += reserved.Bytes
+	}
+	if (r.quota.Images > 0 && used.Images+int64(images) > r.quota.Images) ||
+		(r.quota.Bytes > 0 && used.Bytes+bytes > r.quota.Bytes) {
+		return fmt.Errorf("%w: la petición supera la cuota restante, se reinicia a las %s",
+			domain.ErrQuotaExceeded, t.day.AddDate(0, 0, 1).Format(time.RFC3339))
+	}
+
+	if !ok {
+		reserved = &domain.QuotaUsage{}
+		t.reserved[r.client] = reserved
+	}
+	reserved.Images += int64(images)
+	reserved.Bytes += bytes
+	r.images += int64(images)
+	r.bytes += bytes
+	return nil
+}
+
+// Transfer pasa lo apartado a una nueva reserva y deja vacía r. Sirve para que un trabajo en
+// segundo plano conserve la reserva después de responder a la petición que lo creó.
+func (r *QuotaReservation) Transfer() *QuotaReservation {
+	if r == nil {
+		return nil
+	}
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+
+	moved := *r
+	r.images, r.bytes = 0, 0
+	return &moved
+}
+
+// Release devuelve a la cuota lo apartado por r. Lo que se llegó a comprimir ya se contó en
+// RecordImage, así que solo deja de estar reservado.
+func (r *QuotaReservation) Release() {
+	if r == nil {
+		return
+	}
+	t := r.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover()
+	if !r.day.Equal(t.day) {
+		r.images, r.bytes = 0, 0
+		return
+	}
+	if reserved, ok := t.reserved[r.client]; ok {
+		reserved.Images -= r.images
+		reserved.Bytes -= r.bytes
+		if reserved.Images <= 0 && reserved.Bytes <= 0 {
+			delete(t.reserved, r.client)
+		}
+	}
+	r.images, r.bytes = 0, 0
+}
+
+// quotaReservationContextKey es la clave del contexto bajo la que viaja la reserva de cuota
+type quotaReservationContextKey struct{}
+
+// WithQuotaReservation devuelve una copia de ctx con la reserva de cuota de la petición
+func WithQuotaReservation(ctx context.Context, reservation *QuotaReservation) context.Context {
+	if reservation == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, quotaReservationContextKey{}, reservation)
+}
+
+// QuotaReservationFromContext devuelve la reserva de cuota de la petición, o nil si no tiene
+func QuotaReservationFromContext(ctx context.Context) *QuotaReservation {
+	reservation, _ := ctx.Value(quotaReservationContextKey{}).(*QuotaReservation)
+	return reservation
+}
+
+// startOfDay devuelve las 00:00 UTC del día de now
+func startOfDay(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// secondsToDuration convierte segundos fraccionarios en una duración
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
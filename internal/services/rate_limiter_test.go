@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// fakeClock es un reloj que solo avanza cuando la prueba lo indica
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestRateLimiter crea un RateLimiter con el reloj clock
+func newTestRateLimiter(clock *fakeClock) *RateLimiter {
+	limiter := NewRateLimiter()
+	limiter.now = clock.Now
+	limiter.lastPurge = clock.Now()
+	return limiter
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestRateLimiter(clock)
+	limit := domain.RateLimit{Rate: 2, Burst: 5}
+
+	// Un cliente nuevo puede hacer una ráfaga completa sin esperar
+	for i := 0; i < 5; i++ {
+		status, ok := limiter.Allow("a", limit)
+		if !ok {
+			t.Fatalf("petición %d rechazada dentro de la ráfaga", i+1)
+		}
+		if status.Limit != 5 || status.Remaining != 4-i {
+			t.Fatalf("petición %d: Limit=%d Remaining=%d, se esperaba 5 y %d", i+1, status.Limit, status.Remaining, 4-i)
+		}
+	}
+
+	status, ok := limiter.Allow("a", limit)
+	if ok {
+		t.Fatal("se permitió una petición con el bucket vacío")
+	}
+	if status.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("RetryAfter = %v, se esperaba 500ms con 2 peticiones por segundo", status.RetryAfter)
+	}
+	if status.Reset != 2500*time.Millisecond {
+		t.Fatalf("Reset = %v, se esperaba 2.5s para reponer 5 tokens", status.Reset)
+	}
+
+	// Los buckets son independientes por cliente
+	if _, ok := limiter.Allow("b", limit); !ok {
+		t.Fatal("el bucket vacío de un cliente limitó a otro")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestRateLimiter(clock)
+	limit := domain.RateLimit{Rate: 2, Burst: 5}
+
+	for i := 0; i < 5; i++ {
+		limiter.Allow("a", limit)
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		allowed int // Peticiones permitidas seguidas tras avanzar el reloj
+	}{
+		{name: "antes de reponer un token", advance: 400 * time.Millisecond, allowed: 0},
+		{name: "un token repuesto", advance: 100 * time.Millisecond, allowed: 1},
+		{name: "dos tokens repuestos", advance: time.Second, allowed: 2},
+		{name: "la reposición no supera la ráfaga", advance: time.Hour, allowed: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			allowed := 0
+			for {
+				if _, ok := limiter.Allow("a", limit); !ok {
+					break
+				}
+				allowed++
+			}
+			if allowed != tt.allowed {
+				t.Fatalf("%d peticiones permitidas, se esperaban %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := NewRateLimiter()
+	for i := 0; i < 100; i++ {
+		if _, ok := limiter.Allow("a", domain.RateLimit{Rate: 0, Burst: 1}); !ok {
+			t.Fatal("un límite con Rate en cero rechazó una petición")
+		}
+	}
+}
+
+func TestQuotaTrackerDailyRollover(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)}
+	quotas := NewQuotaTracker()
+	quotas.now = clock.Now
+	quotas.day = startOfDay(clock.Now())
+
+	ctx := domain.WithClient(context.Background(), "key:web")
+	quotas.RecordImage(ctx, 1000, nil)
+	quotas.RecordImage(ctx, 500, nil)
+	// Las imágenes sin cliente no se atribuyen a nadie
+	quotas.RecordImage(context.Background(), 100, nil)
+
+	usage := quotas.Usage("key:web")
+	if usage.Images != 2 || usage.Bytes != 1500 {
+		t.Fatalf("consumo = %d imágenes y %d bytes, se esperaban 2 y 1500", usage.Images, usage.Bytes)
+	}
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !usage.Reset.Equal(want) {
+		t.Fatalf("Reset = %v, se esperaba %v", usage.Reset, want)
+	}
+	if !(domain.Quota{Images: 2}).Exceeds(usage) {
+		t.Fatal("una cuota de 2 imágenes no se agotó con 2 imágenes")
+	}
+
+	// A las 00:00 UTC el consumo vuelve a cero
+	clock.Advance(time.Minute)
+	usage = quotas.Usage("key:web")
+	if usage.Images != 0 || usage.Bytes != 0 {
+		t.Fatalf("consumo tras el cambio de día = %d imágenes y %d bytes, se esperaba cero", usage.Images, usage.Bytes)
+	}
+	if want := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC); !usage.Reset.Equal(want) {
+		t.Fatalf("Reset = %v, se esperaba %v", usage.Reset, want)
+	}
+
+	quotas.RecordImage(ctx, 200, nil)
+	if usage := quotas.Usage("key:web"); usage.Images != 1 || usage.Bytes != 200 {
+		t.Fatalf("consumo del día nuevo = %d imágenes y %d bytes, se esperaban 1 y 200", usage.Images, usage.Bytes)
+	}
+}
+
+func TestQuotaReservation(t *testing.T) {
+	quotas := NewQuotaTracker()
+	quota := domain.Quota{Images: 3, Bytes: 1000}
+	ctx := domain.WithClient(context.Background(), "key:web")
+
+	// Dos peticiones a la vez no pueden apartar juntas más que la cuota
+	first := quotas.Reserve("key:web", quota)
+	if err := first.Add(2, 400); err != nil {
+		t.Fatalf("Add(2, 400) = %v", err)
+	}
+	second := quotas.Reserve("key:web", quota)
+	if err := second.Add(2, 100); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("Add(2, 100) con 2 imágenes apartadas = %v, se esperaba ErrQuotaExceeded", err)
+	}
+	if err := second.Add(1, 700); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("Add(1, 700) con 400 bytes apartados = %v, se esperaba ErrQuotaExceeded", err)
+	}
+	if err := second.Add(1, 600); err != nil {
+		t.Fatalf("Add(1, 600) = %v", err)
+	}
+
+	// first solo llega a comprimir una imagen: al liberar se devuelve la otra
+	quotas.RecordImage(ctx, 300, nil)
+	first.Release()
+	third := quotas.Reserve("key:web", quota)
+	if err := third.Add(1, 100); err != nil {
+		t.Fatalf("Add(1, 100) tras liberar = %v", err)
+	}
+	if err := third.Add(1, 0); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("Add(1, 0) con la cuota completa = %v, se esperaba ErrQuotaExceeded", err)
+	}
+
+	// Transfer mueve la reserva: liberar la original ya no devuelve nada
+	job := third.Transfer()
+	third.Release()
+	if err := quotas.Reserve("key:web", quota).Add(1, 0); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("Add(1, 0) con la reserva transferida = %v, se esperaba ErrQuotaExceeded", err)
+	}
+	job.Release()
+	second.Release()
+	if err := quotas.Reserve("key:web", quota).Add(2, 700); err != nil {
+		t.Fatalf("Add(2, 700) con todo liberado = %v", err)
+	}
+
+	// Sin cuota no hay reserva y nada se limita
+	if reservation := quotas.Reserve("key:web", domain.Quota{}); reservation != nil || reservation.Add(100, 1<<40) != nil {
+		t.Fatal("una cuota sin límites creó una reserva que limita")
+	}
+}
+
+func TestQuotaReservationRollover(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)}
+	quotas := NewQuotaTracker()
+	quotas.now = clock.Now
+	quotas.day = startOfDay(clock.Now())
+	quota := domain.Quota{Images: 2}
+
+	old := quotas.Reserve("key:web", quota)
+	if err := old.Add(2, 0); err != nil {
+		t.Fatalf("Add(2, 0) = %v", err)
+	}
+
+	// Lo apartado el día anterior no cuenta en el nuevo, ni al reservar ni al liberar
+	clock.Advance(time.Minute)
+	current := quotas.Reserve("key:web", quota)
+	if err := current.Add(2, 0); err != nil {
+		t.Fatalf("Add(2, 0) en el día nuevo = %v", err)
+	}
+	old.Release()
+	if err := quotas.Reserve("key:web", quota).Add(1, 0); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("Add(1, 0) = %v; liberar una reserva del día anterior devolvió cuota del nuevo", err)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// S3Config agrupa la configuración de un almacenamiento compatible con S3 (AWS S3, MinIO...)
+type S3Config struct {
+	Endpoint  string // Host y puerto, sin esquema (p. ej. "s3.amazonaws.com" o "localhost:9000")
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3Storage implementa domain.Storage sobre un bucket compatible con S3
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Storage crea el cliente y comprueba que el bucket exista
+func NewS3Storage(ctx context.Context, cfg S3Config) (*S3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creando cliente S3: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error accediendo al bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("el bucket %s no existe", cfg.Bucket)
+	}
+
+	return &S3Storage{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+// Put sube data al bucket con la clave key
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) (*domain.StoredObject, error) {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error subiendo %s: %w", key, err)
+	}
+
+	return &domain.StoredObject{
+		Key:         key,
+		Size:        info.Size,
+		ETag:        `"` + info.ETag + `"`,
+		ContentType: contentType,
+	}, nil
+}
+
+// Get descarga el objeto key del bucket
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *domain.StoredObject, error) {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error descargando %s: %w", key, err)
+	}
+
+	// GetObject no hace la petición hasta leer; Stat obtiene los metadatos y detecta si no existe
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil, nil, domain.ErrObjectNotFound
+		}
+		return nil, nil, fmt.Errorf("error descargando %s: %w", key, err)
+	}
+
+	return object, &domain.StoredObject{
+		Key:         key,
+		Size:        stat.Size,
+		ETag:        `"` + stat.ETag + `"`,
+		ContentType: stat.ContentType,
+	}, nil
+}
+
+// Delete elimina el objeto key del bucket; no es un error si no existe
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	key, err := sanitizeStorageKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("error eliminando %s: %w", key, err)
+	}
+	return nil
+}
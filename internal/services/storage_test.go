@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// testStorageContract comprueba el contrato de domain.Storage que deben cumplir todas las
+// implementaciones
+func testStorageContract(t *testing.T, newStorage func(t *testing.T) domain.Storage) {
+	ctx := context.Background()
+	data := []byte("contenido comprimido")
+
+	// get lee el objeto key completo
+	get := func(t *testing.T, storage domain.Storage, key string) ([]byte, *domain.StoredObject) {
+		t.Helper()
+		reader, object, err := storage.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", key, err)
+		}
+		defer reader.Close()
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("leyendo %s: %v", key, err)
+		}
+		return got, object
+	}
+
+	t.Run("Put y Get", func(t *testing.T) {
+		storage := newStorage(t)
+		put, err := storage.Put(ctx, "jobs/1/foto.png", data, "image/png")
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if put.Key != "jobs/1/foto.png" || put.Size != int64(len(data)) || put.ContentType != "image/png" {
+			t.Fatalf("Put = %+v, no describe el objeto guardado", put)
+		}
+
+		got, object := get(t, storage, "jobs/1/foto.png")
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Get = %q, se esperaba %q", got, data)
+		}
+		if object.Key != put.Key || object.Size != put.Size || object.ContentType != put.ContentType {
+			t.Fatalf("Get = %+v, se esperaba %+v", object, put)
+		}
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		storage := newStorage(t)
+		first, err := storage.Put(ctx, "a.png", data, "image/png")
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if first.ETag != contentETag(data) {
+			t.Fatalf("ETag = %s, se esperaba el MD5 del contenido %s", first.ETag, contentETag(data))
+		}
+		if _, object := get(t, storage, "a.png"); object.ETag != first.ETag {
+			t.Fatalf("Get devolvió el ETag %s, Put devolvió %s", object.ETag, first.ETag)
+		}
+
+		same, err := storage.Put(ctx, "b.png", data, "image/png")
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if same.ETag != first.ETag {
+			t.Fatalf("el mismo contenido tiene ETags distintos: %s y %s", first.ETag, same.ETag)
+		}
+
+		changed, err := storage.Put(ctx, "a.png", []byte("otro contenido"), "image/png")
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if changed.ETag == first.ETag {
+			t.Fatalf("el ETag no cambió al sobrescribir con otro contenido")
+		}
+		if _, object := get(t, storage, "a.png"); object.ETag != changed.ETag {
+			t.Fatalf("Get devolvió el ETag %s tras sobrescribir, se esperaba %s", object.ETag, changed.ETag)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		storage := newStorage(t)
+		if _, err := storage.Put(ctx, "jobs/1/foto.png", data, "image/png"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := storage.Delete(ctx, "jobs/1/foto.png"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, _, err := storage.Get(ctx, "jobs/1/foto.png"); !errors.Is(err, domain.ErrObjectNotFound) {
+			t.Fatalf("Get tras Delete = %v, se esperaba ErrObjectNotFound", err)
+		}
+		// Eliminar un objeto que no existe no es un error
+		if err := storage.Delete(ctx, "jobs/1/foto.png"); err != nil {
+			t.Fatalf("Delete de un objeto inexistente: %v", err)
+		}
+	})
+
+	t.Run("objeto inexistente", func(t *testing.T) {
+		storage := newStorage(t)
+		if _, _, err := storage.Get(ctx, "no/existe.png"); !errors.Is(err, domain.ErrObjectNotFound) {
+			t.Fatalf("Get = %v, se esperaba ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("claves inválidas", func(t *testing.T) {
+		storage := newStorage(t)
+		for _, key := range []string{"../fuera.png", "/etc/passwd", "jobs/../../fuera.png", ""} {
+			if _, err := storage.Put(ctx, key, data, "image/png"); !errors.Is(err, domain.ErrInvalidStorageKey) {
+				t.Errorf("Put(%q) = %v, se esperaba ErrInvalidStorageKey", key, err)
+			}
+			if _, _, err := storage.Get(ctx, key); !errors.Is(err, domain.ErrInvalidStorageKey) {
+				t.Errorf("Get(%q) = %v, se esperaba ErrInvalidStorageKey", key, err)
+			}
+			if err := storage.Delete(ctx, key); !errors.Is(err, domain.ErrInvalidStorageKey) {
+				t.Errorf("Delete(%q) = %v, se esperaba ErrInvalidStorageKey", key, err)
+			}
+		}
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) domain.Storage {
+		return NewMemoryStorage()
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) domain.Storage {
+		storage, err := NewFileStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFileStorage: %v", err)
+		}
+		return storage
+	})
+}
+
+func TestFileStorageETagFile(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	storage, err := NewFileStorage(root)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	etagFile := filepath.Join(root, "jobs", "1", ".foto.png.etag")
+
+	put, err := storage.Put(ctx, "jobs/1/foto.png", []byte("contenido"), "image/png")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if saved, err := os.ReadFile(etagFile); err != nil || string(saved) != put.ETag {
+		t.Fatalf("ETag guardado = %q, %v; se esperaba %s", saved, err, put.ETag)
+	}
+
+	// Get lee el ETag guardado en lugar de recalcularlo
+	if err := os.WriteFile(etagFile, []byte(`"guardado"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	etag := func() string {
+		t.Helper()
+		reader, object, err := storage.Get(ctx, "jobs/1/foto.png")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		defer reader.Close()
+		if data, err := io.ReadAll(reader); err != nil || string(data) != "contenido" {
+			t.Fatalf("Get = %q, %v", data, err)
+		}
+		return object.ETag
+	}
+	if got := etag(); got != `"guardado"` {
+		t.Fatalf("Get devolvió el ETag %s, se esperaba el guardado", got)
+	}
+
+	// Sin el archivo de ETag (objetos anteriores) se recalcula del contenido
+	if err := os.Remove(etagFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := etag(); got != put.ETag {
+		t.Fatalf("Get devolvió el ETag %s, se esperaba el recalculado %s", got, put.ETag)
+	}
+
+	if err := storage.Delete(ctx, "jobs/1/foto.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(etagFile); !os.IsNotExist(err) {
+		t.Fatalf("Delete no eliminó el archivo de ETag: %v", err)
+	}
+
+	// Un objeto no puede ocupar el archivo de ETag de otro
+	if _, err := storage.Put(ctx, "jobs/1/.foto.png.etag", []byte(`"falso"`), "text/plain"); !errors.Is(err, domain.ErrInvalidStorageKey) {
+		t.Fatalf("Put de un archivo de ETag = %v, se esperaba ErrInvalidStorageKey", err)
+	}
+}
+
+// TestS3Storage comprueba el contrato contra un servidor compatible con S3, como MinIO. Solo
+// se ejecuta si se define S3_TEST_ENDPOINT; el bucket S3_TEST_BUCKET debe existir y dedicarse
+// a las pruebas, ya que se escriben y eliminan objetos en él.
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT no está definida")
+	}
+	cfg := S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_TEST_REGION"),
+		Bucket:    os.Getenv("S3_TEST_BUCKET"),
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+	}
+
+	testStorageContract(t, func(t *testing.T) domain.Storage {
+		storage, err := NewS3Storage(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("NewS3Storage: %v", err)
+		}
+		return storage
+	})
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// StorageUploader guarda las imágenes comprimidas directamente en el almacenamiento, en lugar
+// de devolverlas en la respuesta. Cada petición usa su propia carpeta "<prefijo>/<id>/" para
+// que peticiones con los mismos nombres de archivo no se sobrescriban.
+type StorageUploader struct {
+	storage   domain.Storage
+	prefix    string
+	publicURL string // URL desde la que se sirven los objetos, vacía si no se conoce
+}
+
+// NewStorageUploader crea un uploader que guarda los objetos en storage bajo prefix. Si
+// publicURL no está vacía (por ejemplo la URL del bucket o de un CDN delante de él), URL
+// devuelve la URL de cada objeto como publicURL seguida de su clave.
+func NewStorageUploader(storage domain.Storage, prefix, publicURL string) *StorageUploader {
+	return &StorageUploader{
+		storage:   storage,
+		prefix:    strings.Trim(prefix, "/"),
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+// URL devuelve la URL pública del objeto key, o "" si no hay URL pública configurada
+func (u *StorageUploader) URL(key string) string {
+	if u.publicURL == "" {
+		return ""
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return u.publicURL + "/" + strings.Join(segments, "/")
+}
+
+// Upload guarda una imagen con el nombre filename sanitizado en una carpeta nueva
+func (u *StorageUploader) Upload(ctx context.Context, filename string, data []byte) (*domain.StoredObject, error) {
+	return u.put(ctx, u.newDir(), sanitizeFilename(filename), data)
+}
+
+// NewWriter crea un domain.ArchiveWriter que guarda cada entrada como un objeto
+// independiente dentro de una misma carpeta nueva
+func (u *StorageUploader) NewWriter(ctx context.Context) *UploadWriter {
+	return &UploadWriter{
+		ctx:      ctx,
+		uploader: u,
+		dir:      u.newDir(),
+		names:    entryNames{},
+		objects:  make(map[string]*domain.StoredObject),
+	}
+}
+
+// newDir devuelve una carpeta única para los objetos de una petición
+func (u *StorageUploader) newDir() string {
+	return path.Join(u.prefix, uuid.NewString())
+}
+
+// put guarda data con la clave dir/name, detectando el tipo MIME por su contenido
+func (u *StorageUploader) put(ctx context.Context, dir, name string, data []byte) (*domain.StoredObject, error) {
+	object, err := u.storage.Put(ctx, path.Join(dir, name), data, http.DetectContentType(data))
+	if err != nil {
+		return nil, fmt.Errorf("error guardando %s: %w", name, err)
+	}
+	return object, nil
+}
+
+// UploadWriter implementa domain.ArchiveWriter guardando cada entrada en el almacenamiento.
+// Los nombres se sanitizan y desambiguan igual que en los archivos ZIP y TAR.
+type UploadWriter struct {
+	ctx      context.Context
+	uploader *StorageUploader
+	dir      string
+	names    entryNames
+	objects  map[string]*domain.StoredObject
+}
+
+// AddFile guarda un archivo con el nombre sanitizado y sin repetir
+func (w *UploadWriter) AddFile(filename string, data []byte) (string, error) {
+	name := w.names.unique(sanitizeFilename(filename))
+	return name, w.add(name, data)
+}
+
+// AddPath guarda un archivo conservando la estructura de carpetas
+func (w *UploadWriter) AddPath(filePath string, data []byte) (string, error) {
+	sanitizedPath, err := sanitizePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, filePath)
+	}
+	sanitizedPath = w.names.unique(sanitizedPath)
+	return sanitizedPath, w.add(sanitizedPath, data)
+}
+
+// AddManifest no guarda nada: el manifiesto se devuelve en la respuesta
+func (w *UploadWriter) AddManifest(manifest *domain.BatchManifest, withCSV bool) error {
+	return nil
+}
+
+// Close no hace nada: cada objeto se guarda completo al agregarlo
+func (w *UploadWriter) Close() error {
+	return nil
+}
+
+// Object devuelve el objeto guardado para la entrada name, o nil si no existe
+func (w *UploadWriter) Object(name string) *domain.StoredObject {
+	return w.objects[name]
+}
+
+// URL devuelve la URL pública del objeto key, ver StorageUploader.URL
+func (w *UploadWriter) URL(key string) string {
+	return w.uploader.URL(key)
+}
+
+// Discard elimina los objetos ya guardados, para no dejar lotes a medias cuando la petición falla
+func (w *UploadWriter) Discard() {
+	ctx := context.WithoutCancel(w.ctx)
+	for name, object := range w.objects {
+		if err := w.uploader.storage.Delete(ctx, object.Key); err != nil {
+			slog.WarnContext(ctx, "Error eliminando objeto subido", "key", object.Key, "error", err)
+		}
+		delete(w.objects, name)
+	}
+}
+
+// add guarda la entrada name y registra el objeto resultante
+func (w *UploadWriter) add(name string, data []byte) error {
+	object, err := w.uploader.put(w.ctx, w.dir, name, data)
+	if err != nil {
+		return err
+	}
+	w.objects[name] = object
+	return nil
+}
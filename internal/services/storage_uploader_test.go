@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// uploadedKey es la forma de las claves de StorageUploader: prefijo, carpeta única y nombre
+var uploadedKey = regexp.MustCompile(`^(.*/)?[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}/[^/].*$`)
+
+// readObject lee el objeto key de storage con su tipo de contenido
+func readObject(t *testing.T, storage domain.Storage, key string) (string, string) {
+	t.Helper()
+	reader, object, err := storage.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), object.ContentType
+}
+
+func TestStorageUploaderUpload(t *testing.T) {
+	png := testPNG(t)
+	tests := []struct {
+		name            string
+		prefix          string
+		publicURL       string
+		filename        string
+		data            []byte
+		wantPrefix      string // Prefijo de la clave, sin la carpeta única
+		wantName        string // Nombre del objeto dentro de la carpeta
+		wantContentType string
+		wantURL         string // URL esperada con <clave> en lugar de la clave, "" sin URL
+	}{
+		{
+			name: "imagen", prefix: "compressed", filename: "foto.png", data: png,
+			wantPrefix: "compressed/", wantName: "foto.png", wantContentType: "image/png",
+		},
+		{
+			name: "barras en el prefijo", prefix: "/salida/compressed/", filename: "foto.png", data: png,
+			wantPrefix: "salida/compressed/", wantName: "foto.png", wantContentType: "image/png",
+		},
+		{
+			name: "sin prefijo", filename: "foto.png", data: png,
+			wantName: "foto.png", wantContentType: "image/png",
+		},
+		{
+			name: "nombre con ruta", prefix: "compressed", filename: "../../etc/foto.png", data: png,
+			wantPrefix: "compressed/", wantName: "foto.png", wantContentType: "image/png",
+		},
+		{
+			name: "tipo por contenido", prefix: "compressed", filename: "foto.png", data: []byte("no es una imagen"),
+			wantPrefix: "compressed/", wantName: "foto.png", wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name: "URL pública", prefix: "compressed", publicURL: "https://cdn.example.com/imagenes/", filename: "foto.png", data: png,
+			wantPrefix: "compressed/", wantName: "foto.png", wantContentType: "image/png",
+			wantURL: "https://cdn.example.com/imagenes/<clave>",
+		},
+		{
+			name: "URL pública con espacios", prefix: "compressed", publicURL: "https://cdn.example.com", filename: "mi foto#1.png", data: png,
+			wantPrefix: "compressed/", wantName: "mi foto#1.png", wantContentType: "image/png",
+			wantURL: "https://cdn.example.com/<clave>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			uploader := NewStorageUploader(storage, tt.prefix, tt.publicURL)
+
+			object, err := uploader.Upload(context.Background(), tt.filename, tt.data)
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if !uploadedKey.MatchString(object.Key) || !strings.HasPrefix(object.Key, tt.wantPrefix) || path.Base(object.Key) != tt.wantName {
+				t.Fatalf("clave %q, se esperaba %s<uuid>/%s", object.Key, tt.wantPrefix, tt.wantName)
+			}
+			if tt.wantPrefix == "" && strings.Count(object.Key, "/") != 1 {
+				t.Fatalf("clave %q, sin prefijo se esperaba <uuid>/%s", object.Key, tt.wantName)
+			}
+			if object.ContentType != tt.wantContentType || object.Size != int64(len(tt.data)) || object.ETag != contentETag(tt.data) {
+				t.Fatalf("objeto %+v, se esperaba %s de %d bytes", object, tt.wantContentType, len(tt.data))
+			}
+
+			data, contentType := readObject(t, storage, object.Key)
+			if data != string(tt.data) || contentType != tt.wantContentType {
+				t.Fatalf("el almacenamiento tiene %d bytes de %s", len(data), contentType)
+			}
+
+			// En la URL cada segmento de la clave va escapado
+			escapedKey := strings.ReplaceAll(object.Key, " ", "%20")
+			escapedKey = strings.ReplaceAll(escapedKey, "#", "%23")
+			wantURL := strings.ReplaceAll(tt.wantURL, "<clave>", escapedKey)
+			if got := uploader.URL(object.Key); got != wantURL {
+				t.Fatalf("URL = %q, se esperaba %q", got, wantURL)
+			}
+
+			// Cada subida usa su propia carpeta
+			again, err := uploader.Upload(context.Background(), tt.filename, tt.data)
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if again.Key == object.Key {
+				t.Fatalf("dos subidas de %s usaron la misma clave %s", tt.filename, object.Key)
+			}
+		})
+	}
+}
+
+func TestUploadWriter(t *testing.T) {
+	storage := NewMemoryStorage()
+	uploader := NewStorageUploader(storage, "compressed", "https://cdn.example.com")
+	writer := uploader.NewWriter(context.Background())
+	png := testPNG(t)
+
+	var names []string
+	for _, filename := range []string{"foto.png", "Foto.png", "../foto.png"} {
+		name, err := writer.AddFile(filename, png)
+		if err != nil {
+			t.Fatalf("AddFile(%s): %v", filename, err)
+		}
+		names = append(names, name)
+	}
+	name, err := writer.AddPath("album/2024/foto.png", []byte("texto"))
+	if err != nil {
+		t.Fatalf("AddPath: %v", err)
+	}
+	names = append(names, name)
+	if _, err := writer.AddPath("../fuera.png", png); !errors.Is(err, domain.ErrUnsafeArchivePath) {
+		t.Fatalf("AddPath con .. = %v, se esperaba ErrUnsafeArchivePath", err)
+	}
+	if err := writer.AddManifest(testManifest(), true); err != nil {
+		t.Fatalf("AddManifest: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	wantNames := []string{"foto.png", "Foto (1).png", "foto (2).png", "album/2024/foto.png"}
+	wantTypes := []string{"image/png", "image/png", "image/png", "text/plain; charset=utf-8"}
+	var dir string
+	for i, name := range names {
+		if name != wantNames[i] {
+			t.Fatalf("entrada %d: %q, se esperaba %q", i, name, wantNames[i])
+		}
+		object := writer.Object(name)
+		if object == nil {
+			t.Fatalf("no hay objeto para %s", name)
+		}
+		// Todas las entradas comparten la carpeta de la petición
+		entryDir := strings.TrimSuffix(object.Key, "/"+name)
+		if dir == "" {
+			dir = entryDir
+		}
+		if entryDir != dir || !uploadedKey.MatchString(object.Key) || !strings.HasPrefix(dir, "compressed/") {
+			t.Fatalf("clave %q, se esperaba %s/%s", object.Key, dir, name)
+		}
+		if _, contentType := readObject(t, storage, object.Key); contentType != wantTypes[i] || object.ContentType != wantTypes[i] {
+			t.Fatalf("%s guardado como %s, se esperaba %s", name, contentType, wantTypes[i])
+		}
+		escapedKey := strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(object.Key)
+		if got, want := writer.URL(object.Key), "https://cdn.example.com/"+escapedKey; got != want {
+			t.Fatalf("URL = %q, se esperaba %q", got, want)
+		}
+	}
+	if writer.Object("no-existe.png") != nil {
+		t.Fatalf("Object devolvió un objeto para una entrada que no se agregó")
+	}
+
+	// El manifiesto se devuelve en la respuesta, no se guarda
+	for _, name := range []string{manifestJSONName, manifestCSVName} {
+		if _, _, err := storage.Get(context.Background(), dir+"/"+name); !errors.Is(err, domain.ErrObjectNotFound) {
+			t.Fatalf("se guardó %s: %v", name, err)
+		}
+	}
+
+	// Discard elimina lo ya guardado cuando el lote se aborta
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, writer.Object(name).Key)
+	}
+	writer.Discard()
+	for i, key := range keys {
+		if _, _, err := storage.Get(context.Background(), key); !errors.Is(err, domain.ErrObjectNotFound) {
+			t.Fatalf("%s sigue en el almacenamiento tras Discard: %v", key, err)
+		}
+		if writer.Object(names[i]) != nil {
+			t.Fatalf("Object(%s) sigue devolviendo el objeto tras Discard", names[i])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// TarService implementa la interfaz ArchiveService para archivos TAR y TAR.GZ
+type TarService struct {
+	gzip bool
+}
+
+// NewTarService crea una nueva instancia del servicio TAR
+func NewTarService() *TarService {
+	return &TarService{}
+}
+
+// NewTarGzService crea una nueva instancia del servicio TAR comprimido con gzip
+func NewTarGzService() *TarService {
+	return &TarService{gzip: true}
+}
+
+// Format devuelve el formato de archivo que genera el servicio
+func (s *TarService) Format() domain.ArchiveFormat {
+	if s.gzip {
+		return domain.ArchiveTARGZ
+	}
+	return domain.ArchiveTAR
+}
+
+// ContentType devuelve el tipo MIME de los archivos generados
+func (s *TarService) ContentType() string {
+	if s.gzip {
+		return "application/gzip"
+	}
+	return "application/x-tar"
+}
+
+// CreateArchive crea un archivo TAR con los archivos proporcionados
+func (s *TarService) CreateArchive(files []domain.ImageData) ([]byte, error) {
+	return createArchive(s, files)
+}
+
+// NewWriter crea un writer que agrega entradas al TAR a medida que se reciben
+func (s *TarService) NewWriter(w io.Writer) domain.ArchiveWriter {
+	return s.newWriter(w, false)
+}
+
+// NewStreamWriter crea un writer que envía cada entrada al destino en cuanto se agrega
+func (s *TarService) NewStreamWriter(w io.Writer) domain.ArchiveWriter {
+	return s.newWriter(w, true)
+}
+
+// newWriter crea el writer TAR, con gzip si corresponde
+func (s *TarService) newWriter(w io.Writer, stream bool) *tarEntryWriter {
+	writer := &tarEntryWriter{names: newEntryNames(), stream: stream}
+	if stream {
+		writer.flusher = flusherOf(w)
+	}
+	if s.gzip {
+		// La cabecera gzip no lleva nombre ni fecha, así que la salida es reproducible
+		writer.gzip, _ = gzip.NewWriterLevel(w, compressionLevel)
+		w = writer.gzip
+	}
+	writer.writer = tar.NewWriter(w)
+	return writer
+}
+
+// tarEntryWriter implementa domain.ArchiveWriter sobre un tar.Writer
+type tarEntryWriter struct {
+	writer  *tar.Writer
+	gzip    *gzip.Writer
+	names   entryNames
+	stream  bool
+	flusher interface{ Flush() }
+}
+
+// AddFile agrega un archivo al TAR con el nombre sanitizado y sin repetir
+func (t *tarEntryWriter) AddFile(filename string, data []byte) (string, error) {
+	sanitizedFilename := t.names.unique(sanitizeFilename(filename))
+	return sanitizedFilename, t.add(filename, sanitizedFilename, data)
+}
+
+// AddPath agrega un archivo al TAR conservando la estructura de carpetas
+func (t *tarEntryWriter) AddPath(path string, data []byte) (string, error) {
+	sanitizedPath, err := sanitizePath(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, path)
+	}
+	sanitizedPath = t.names.unique(sanitizedPath)
+	return sanitizedPath, t.add(path, sanitizedPath, data)
+}
+
+// AddManifest agrega el manifiesto del lote (manifest.json y opcionalmente manifest.csv)
+func (t *tarEntryWriter) AddManifest(manifest *domain.BatchManifest, withCSV bool) error {
+	return writeManifest(t.add, manifest, withCSV)
+}
+
+// add crea la entrada sanitizedName con los datos del archivo filename
+func (t *tarEntryWriter) add(filename, sanitizedName string, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     sanitizedName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  archiveModTime,
+	}
+	if err := t.writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("error creando entrada TAR para %s: %w", filename, err)
+	}
+
+	if _, err := t.writer.Write(data); err != nil {
+		return fmt.Errorf("error escribiendo datos para %s: %w", filename, err)
+	}
+
+	// En modo streaming enviar la entrada inmediatamente
+	if t.stream {
+		if err := t.flush(); err != nil {
+			return fmt.Errorf("error enviando datos para %s: %w", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// flush vacía el tar, el gzip y el destino
+func (t *tarEntryWriter) flush() error {
+	if err := t.writer.Flush(); err != nil {
+		return err
+	}
+	if t.gzip != nil {
+		if err := t.gzip.Flush(); err != nil {
+			return err
+		}
+	}
+	if t.flusher != nil {
+		t.flusher.Flush()
+	}
+	return nil
+}
+
+// Close escribe el final del TAR y cierra el gzip
+func (t *tarEntryWriter) Close() error {
+	if err := t.writer.Close(); err != nil {
+		return fmt.Errorf("error cerrando TAR: %w", err)
+	}
+	if t.gzip != nil {
+		if err := t.gzip.Close(); err != nil {
+			return fmt.Errorf("error cerrando gzip: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// tarEntry es una entrada leída de un TAR
+type tarEntry struct {
+	header *tar.Header
+	data   []byte
+}
+
+// readTar lee todas las entradas de un TAR, descomprimiéndolo antes si gzipped es true
+func readTar(t *testing.T, data []byte, gzipped bool) []tarEntry {
+	t.Helper()
+	var reader io.Reader = bytes.NewReader(data)
+	if gzipped {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			t.Fatalf("el archivo no es gzip: %v", err)
+		}
+		if gz.Name != "" || !gz.ModTime.IsZero() {
+			t.Errorf("la cabecera gzip lleva nombre %q y fecha %v", gz.Name, gz.ModTime)
+		}
+		reader = gz
+	}
+
+	var entries []tarEntry
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("leyendo TAR: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("leyendo %s: %v", header.Name, err)
+		}
+		entries = append(entries, tarEntry{header: header, data: content})
+	}
+}
+
+func TestTarServiceRoundTrip(t *testing.T) {
+	tests := []struct {
+		name            string
+		service         *TarService
+		stream          bool
+		wantFormat      domain.ArchiveFormat
+		wantContentType string
+	}{
+		{name: "tar", service: NewTarService(), wantFormat: domain.ArchiveTAR, wantContentType: "application/x-tar"},
+		{name: "tar en streaming", service: NewTarService(), stream: true, wantFormat: domain.ArchiveTAR, wantContentType: "application/x-tar"},
+		{name: "tar.gz", service: NewTarGzService(), wantFormat: domain.ArchiveTARGZ, wantContentType: "application/gzip"},
+		{name: "tar.gz en streaming", service: NewTarGzService(), stream: true, wantFormat: domain.ArchiveTARGZ, wantContentType: "application/gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.service.Format() != tt.wantFormat || tt.service.ContentType() != tt.wantContentType {
+				t.Fatalf("formato %s (%s), se esperaba %s (%s)", tt.service.Format(), tt.service.ContentType(), tt.wantFormat, tt.wantContentType)
+			}
+
+			build := func() ([]byte, bool) {
+				rec := httptest.NewRecorder()
+				writer := tt.service.NewWriter(rec)
+				if tt.stream {
+					writer = tt.service.NewStreamWriter(rec)
+				}
+				for _, file := range []struct{ name, data string }{
+					{"foto.jpg", "primera"},
+					{"foto.jpg", "segunda, más larga"},
+					{"../fuera.png", ""},
+				} {
+					if _, err := writer.AddFile(file.name, []byte(file.data)); err != nil {
+						t.Fatalf("AddFile(%s): %v", file.name, err)
+					}
+				}
+				if _, err := writer.AddPath("album/2024/playa.webp", []byte("con carpeta")); err != nil {
+					t.Fatalf("AddPath: %v", err)
+				}
+				if err := writer.AddManifest(&domain.BatchManifest{Succeeded: 4, Images: []domain.ManifestEntry{}}, true); err != nil {
+					t.Fatalf("AddManifest: %v", err)
+				}
+				if err := writer.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+				return rec.Body.Bytes(), rec.Flushed
+			}
+
+			data, flushed := build()
+			if flushed != tt.stream {
+				t.Errorf("flushed = %v, en streaming cada entrada se envía al agregarla", flushed)
+			}
+
+			want := []struct {
+				name string
+				data string
+			}{
+				{"foto.jpg", "primera"},
+				{"foto (1).jpg", "segunda, más larga"},
+				{"fuera.png", ""},
+				{"album/2024/playa.webp", "con carpeta"},
+				{manifestJSONName, ""},
+				{manifestCSVName, ""},
+			}
+			entries := readTar(t, data, tt.wantFormat == domain.ArchiveTARGZ)
+			if len(entries) != len(want) {
+				t.Fatalf("el TAR tiene %d entradas, se esperaban %d", len(entries), len(want))
+			}
+			for i, entry := range entries {
+				header := entry.header
+				if header.Name != want[i].name {
+					t.Errorf("entrada %d: %q, se esperaba %q", i, header.Name, want[i].name)
+				}
+				if want[i].data != "" && string(entry.data) != want[i].data {
+					t.Errorf("%s contiene %q, se esperaba %q", header.Name, entry.data, want[i].data)
+				}
+				if header.Size != int64(len(entry.data)) {
+					t.Errorf("%s declara %d bytes y contiene %d", header.Name, header.Size, len(entry.data))
+				}
+				if header.Typeflag != tar.TypeReg || header.Mode != 0644 {
+					t.Errorf("%s es de tipo %c con modo %o, se esperaba un archivo 0644", header.Name, header.Typeflag, header.Mode)
+				}
+				if !header.ModTime.Equal(archiveModTime) {
+					t.Errorf("%s tiene fecha %v, se esperaba %v", header.Name, header.ModTime, archiveModTime)
+				}
+			}
+
+			// Con la fecha fija, el mismo contenido genera el mismo archivo
+			if again, _ := build(); !bytes.Equal(again, data) {
+				t.Errorf("dos archivos con el mismo contenido no son idénticos")
+			}
+		})
+	}
+}
+
+func TestTarServiceCreateArchive(t *testing.T) {
+	if _, err := NewTarService().CreateArchive(nil); err == nil {
+		t.Fatal("CreateArchive sin archivos no devolvió error")
+	}
+
+	data, err := NewTarGzService().CreateArchive([]domain.ImageData{
+		{Filename: "a.jpg", Data: []byte("a")},
+		{Filename: "sub/b.jpg", Data: []byte("bb")},
+	})
+	if err != nil {
+		t.Fatalf("CreateArchive: %v", err)
+	}
+	entries := readTar(t, data, true)
+	if len(entries) != 2 || entries[0].header.Name != "a.jpg" || entries[1].header.Name != "b.jpg" || string(entries[1].data) != "bb" {
+		t.Fatalf("entradas inesperadas: %+v", entries)
+	}
+}
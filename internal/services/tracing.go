@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifica a la API como origen de los spans
+const tracerName = "github.com/miguelmoralesr13/image-compress"
+
+// tracer crea los spans de la API. Usa el TracerProvider global, así que mientras no se
+// configure uno con otel.SetTracerProvider los spans no se registran.
+var tracer = otel.Tracer(tracerName)
+
+// NewTracerProvider crea el TracerProvider que envía los spans a exporter en lotes. El nombre
+// del servicio es serviceName salvo que se defina OTEL_SERVICE_NAME; el muestreo se puede
+// ajustar con OTEL_TRACES_SAMPLER. Hay que llamar a Shutdown al terminar para enviar los
+// spans pendientes.
+func NewTracerProvider(ctx context.Context, exporter sdktrace.SpanExporter, serviceName string) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creando el recurso de trazas: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// NewOTLPTracerProvider crea un TracerProvider que envía los spans por OTLP/HTTP. El
+// exportador lee el endpoint, los headers y el resto de su configuración de las variables
+// OTEL_EXPORTER_OTLP_*.
+func NewOTLPTracerProvider(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creando el exportador OTLP: %w", err)
+	}
+	return NewTracerProvider(ctx, exporter, serviceName)
+}
+
+// StartSpan inicia un span hijo del que viaja en ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan termina el span, marcándolo como fallido si err no es nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartServerSpan inicia el span de una petición HTTP recibida. Si el cliente envía el
+// header traceparent, el span continúa su traza.
+func StartServerSpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+}
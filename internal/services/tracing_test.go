@@ -0,0 +1,120 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordedSpans instala, una vez por proceso, un TracerProvider global que guarda los spans
+// en memoria. tracer delega en el primer TracerProvider global, así que no se puede cambiar
+// en cada prueba: cada una filtra sus spans por el ID de su traza.
+func recordedSpans(t *testing.T) func(traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return func(traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range spanRecorder.Ended() {
+			if span.SpanContext().TraceID() == traceID {
+				spans[span.Name()] = span
+			}
+		}
+		return spans
+	}
+}
+
+// testPNG devuelve una imagen PNG de 16x16
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressImageSpans(t *testing.T) {
+	spansOf := recordedSpans(t)
+	processor := NewImageProcessorService(1<<20, nil)
+
+	ctx, root := StartSpan(context.Background(), "test")
+	if _, err := processor.CompressImage(ctx, testPNG(t), 80, domain.JPEG, domain.IfLargerKeep); err != nil {
+		t.Fatalf("CompressImage: %v", err)
+	}
+	root.End()
+
+	spans := spansOf(root.SpanContext().TraceID())
+	compress, ok := spans["image.compress"]
+	if !ok {
+		t.Fatalf("no se registró image.compress, spans: %v", spans)
+	}
+	if compress.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("image.compress no es hijo del span de la petición")
+	}
+	for _, name := range []string{"image.decode", "image.encode"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no se registró %s", name)
+		}
+		if span.Parent().SpanID() != compress.SpanContext().SpanID() {
+			t.Errorf("%s no es hijo de image.compress", name)
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("%s terminó con error: %s", name, span.Status().Description)
+		}
+	}
+}
+
+func TestCompressImageSpanErrors(t *testing.T) {
+	spansOf := recordedSpans(t)
+	processor := NewImageProcessorService(1<<20, nil)
+
+	ctx, root := StartSpan(context.Background(), "test")
+	if _, err := processor.CompressImage(ctx, []byte("no es una imagen"), 80, domain.JPEG, ""); err == nil {
+		t.Fatal("CompressImage no devolvió error con datos inválidos")
+	}
+	root.End()
+
+	spans := spansOf(root.SpanContext().TraceID())
+	for _, name := range []string{"image.compress", "image.decode"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no se registró %s", name)
+		}
+		if span.Status().Code != codes.Error {
+			t.Errorf("%s tiene estado %v, se esperaba Error", name, span.Status().Code)
+		}
+		if len(span.Events()) == 0 || span.Events()[0].Name != "exception" {
+			t.Errorf("%s no registró el error como evento", name)
+		}
+	}
+	if _, ok := spans["image.encode"]; ok {
+		t.Errorf("se registró image.encode aunque la decodificación falló")
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// maxTopErrors es el número de códigos de error que se devuelven en las estadísticas
+const maxTopErrors = 10
+
+// UsageStats implementa domain.StatsRecorder acumulando en memoria los contadores de uso
+// desde el arranque del servidor, en total y por API key
+type UsageStats struct {
+	mu    sync.Mutex
+	since time.Time
+	total *usageTotals
+	keys  map[string]*usageTotals // Por nombre de API key
+}
+
+// usageTotals son los contadores de toda la API o de una API key
+type usageTotals struct {
+	usage   usageCounters
+	formats map[domain.ImageFormat]*usageCounters
+	errors  map[string]int64
+}
+
+// usageCounters son los contadores de un conjunto de imágenes comprimidas
+type usageCounters struct {
+	images      int64
+	inputBytes  int64
+	outputBytes int64
+	ratioSum    float64 // Suma de las relaciones salida/entrada, para la media
+}
+
+// NewUsageStats crea los contadores vacíos
+func NewUsageStats() *UsageStats {
+	return &UsageStats{
+		since: time.Now(),
+		total: newUsageTotals(),
+		keys:  make(map[string]*usageTotals),
+	}
+}
+
+// RecordImage registra una imagen comprimida de inputSize bytes
+func (s *UsageStats) RecordImage(ctx context.Context, inputSize int, result *domain.CompressionResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total.addImage(inputSize, result)
+	if totals := s.keyTotals(ctx); totals != nil {
+		totals.addImage(inputSize, result)
+	}
+}
+
+// RecordError registra una imagen que no se pudo comprimir, por su código de error del dominio
+func (s *UsageStats) RecordError(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := domain.ErrorCode(err)
+	s.total.errors[code]++
+	if totals := s.keyTotals(ctx); totals != nil {
+		totals.errors[code]++
+	}
+}
+
+// keyTotals devuelve los contadores de la API key de ctx, o nil si no hay. Requiere s.mu.
+func (s *UsageStats) keyTotals(ctx context.Context) *usageTotals {
+	key := domain.APIKeyFromContext(ctx)
+	if key == nil {
+		return nil
+	}
+	totals, ok := s.keys[key.Name]
+	if !ok {
+		totals = newUsageTotals()
+		s.keys[key.Name] = totals
+	}
+	return totals
+}
+
+// Snapshot devuelve las estadísticas acumuladas hasta el momento, en total y por API key
+func (s *UsageStats) Snapshot() *domain.UsageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &domain.UsageStats{
+		Since:         s.since.UTC(),
+		UptimeSeconds: int64(time.Since(s.since).Seconds()),
+		UsageSummary:  *s.total.summary(),
+	}
+	if len(s.keys) > 0 {
+		stats.Keys = make(map[string]*domain.UsageSummary, len(s.keys))
+		for name, totals := range s.keys {
+			stats.Keys[name] = totals.summary()
+		}
+	}
+	return stats
+}
+
+// KeySnapshot devuelve las estadísticas acumuladas hasta el momento de la API key name,
+// sin las del resto de claves
+func (s *UsageStats) KeySnapshot(name string) *domain.UsageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals, ok := s.keys[name]
+	if !ok {
+		totals = newUsageTotals()
+	}
+	return &domain.UsageStats{
+		Since:         s.since.UTC(),
+		UptimeSeconds: int64(time.Since(s.since).Seconds()),
+		Key:           name,
+		UsageSummary:  *totals.summary(),
+	}
+}
+
+// newUsageTotals crea contadores vacíos
+func newUsageTotals() *usageTotals {
+	return &usageTotals{
+		formats: make(map[domain.ImageFormat]*usageCounters),
+		errors:  make(map[string]int64),
+	}
+}
+
+// addImage suma una imagen comprimida, en total y en su formato de salida
+func (t *usageTotals) addImage(inputSize int, result *domain.CompressionResult) {
+	format, ok := t.formats[result.Format]
+	if !ok {
+		format = &usageCounters{}
+		t.formats[result.Format] = format
+	}
+	t.usage.add(inputSize, len(result.Data))
+	format.add(inputSize, len(result.Data))
+}
+
+// summary resume los contadores, con los errores más frecuentes primero
+func (t *usageTotals) summary() *domain.UsageSummary {
+	summary := &domain.UsageSummary{
+		ImagesProcessed: t.usage.images,
+		InputBytes:      t.usage.inputBytes,
+		OutputBytes:     t.usage.outputBytes,
+		BytesSaved:      t.usage.inputBytes - t.usage.outputBytes,
+		AverageRatio:    t.usage.averageRatio(),
+		Formats:         make(map[domain.ImageFormat]*domain.FormatStats, len(t.formats)),
+		TopErrors:       make([]domain.ErrorCount, 0, len(t.errors)),
+	}
+	for format, counters := range t.formats {
+		summary.Formats[format] = &domain.FormatStats{
+			Images:       counters.images,
+			InputBytes:   counters.inputBytes,
+			OutputBytes:  counters.outputBytes,
+			BytesSaved:   counters.inputBytes - counters.outputBytes,
+			AverageRatio: counters.averageRatio(),
+		}
+	}
+
+	for code, count := range t.errors {
+		summary.ImagesFailed += count
+		summary.TopErrors = append(summary.TopErrors, domain.ErrorCount{Code: code, Count: count})
+	}
+	sort.Slice(summary.TopErrors, func(i, j int) bool {
+		if summary.TopErrors[i].Count != summary.TopErrors[j].Count {
+			return summary.TopErrors[i].Count > summary.TopErrors[j].Count
+		}
+		return summary.TopErrors[i].Code < summary.TopErrors[j].Code
+	})
+	if len(summary.TopErrors) > maxTopErrors {
+		summary.TopErrors = summary.TopErrors[:maxTopErrors]
+	}
+	return summary
+}
+
+// add suma una imagen a los contadores
+func (c *usageCounters) add(inputSize, outputSize int) {
+	c.images++
+	c.inputBytes += int64(inputSize)
+	c.outputBytes += int64(outputSize)
+	if inputSize > 0 {
+		c.ratioSum += float64(outputSize) / float64(inputSize)
+	}
+}
+
+// averageRatio devuelve la media de las relaciones salida/entrada, redondeada a 4 decimales
+func (c *usageCounters) averageRatio() float64 {
+	if c.images == 0 {
+		return 0
+	}
+	return math.Round(c.ratioSum/float64(c.images)*10000) / 10000
+}
+
+// StatsRecorders reparte cada imagen y cada error entre varios domain.StatsRecorder
+type StatsRecorders []domain.StatsRecorder
+
+// RecordImage registra la imagen en todos los recorders
+func (r StatsRecorders) RecordImage(ctx context.Context, inputSize int, result *domain.CompressionResult) {
+	for _, recorder := range r {
+		recorder.RecordImage(ctx, inputSize, result)
+	}
+}
+
+// RecordError registra el error en todos los recorders
+func (r StatsRecorders) RecordError(ctx context.Context, err error) {
+	for _, recorder := range r {
+		recorder.RecordError(ctx, err)
+	}
+}
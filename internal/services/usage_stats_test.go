@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+func TestUsageStatsKeySnapshot(t *testing.T) {
+	stats := NewUsageStats()
+	ctxA := domain.WithAPIKey(context.Background(), &domain.APIKey{Name: "a"})
+	ctxB := domain.WithAPIKey(context.Background(), &domain.APIKey{Name: "b"})
+
+	result := &domain.CompressionResult{Format: domain.JPEG, Data: make([]byte, 40)}
+	stats.RecordImage(ctxA, 100, result)
+	stats.RecordImage(ctxB, 100, result)
+	stats.RecordImage(ctxB, 100, result)
+	stats.RecordError(ctxB, domain.ErrInvalidImageData)
+
+	tests := []struct {
+		key        string
+		wantImages int64
+		wantFailed int64
+	}{
+		{key: "a", wantImages: 1},
+		{key: "b", wantImages: 2, wantFailed: 1},
+		{key: "sin-uso"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			snapshot := stats.KeySnapshot(tt.key)
+			if snapshot.Key != tt.key || snapshot.Keys != nil {
+				t.Fatalf("KeySnapshot(%q) = clave %q con %d claves, se esperaba solo la propia", tt.key, snapshot.Key, len(snapshot.Keys))
+			}
+			if snapshot.ImagesProcessed != tt.wantImages || snapshot.ImagesFailed != tt.wantFailed {
+				t.Fatalf("KeySnapshot(%q) = %d imágenes y %d errores, se esperaban %d y %d",
+					tt.key, snapshot.ImagesProcessed, snapshot.ImagesFailed, tt.wantImages, tt.wantFailed)
+			}
+		})
+	}
+
+	full := stats.Snapshot()
+	if full.ImagesProcessed != 3 || len(full.Keys) != 2 {
+		t.Fatalf("Snapshot = %d imágenes y %d claves, se esperaban 3 y 2", full.ImagesProcessed, len(full.Keys))
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// Headers de las notificaciones webhook
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookEventHeader     = "X-Webhook-Event"
+)
+
+// WebhookNotifier implementa domain.JobNotifier enviando un POST JSON firmado con HMAC-SHA256.
+// Los fallos de red, 429 y 5xx se reintentan con backoff exponencial. Salvo que se permita
+// expresamente, no se envían notificaciones a direcciones privadas, de loopback o link-local.
+type WebhookNotifier struct {
+	client       *http.Client
+	secret       []byte
+	maxRetries   int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	timeout      time.Duration // Duración máxima de una notificación, con todos sus reintentos
+	allowPrivate bool
+}
+
+// NewWebhookNotifier crea un notificador. secret firma las notificaciones; sin él se rechazan
+// las callback_url. maxRetries es el número de reintentos tras el primer intento, baseDelay la
+// espera antes del primero, que se duplica en cada reintento, y timeout la duración máxima de
+// cada notificación. allowPrivate permite enviarlas a la red local, para desarrollo.
+func NewWebhookNotifier(secret string, maxRetries int, baseDelay, timeout time.Duration, allowPrivate bool) *WebhookNotifier {
+	n := &WebhookNotifier{
+		secret:       []byte(secret),
+		maxRetries:   maxRetries,
+		baseDelay:    baseDelay,
+		maxDelay:     5 * time.Minute,
+		timeout:      timeout,
+		allowPrivate: allowPrivate,
+	}
+
+	// La dirección se comprueba otra vez al conectar: entre la validación de la URL y el envío
+	// el DNS puede cambiar (DNS rebinding), y las redirecciones pueden apuntar a otro host
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = checkWebhookDial
+	}
+	n.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return n
+}
+
+// ValidateURL comprueba que se pueda notificar a rawURL: el servidor debe tener clave de firma
+// y la URL debe ser HTTP(S) absoluta y resolver solo a direcciones públicas
+func (n *WebhookNotifier) ValidateURL(ctx context.Context, rawURL string) error {
+	if len(n.secret) == 0 {
+		return domain.ErrWebhookDisabled
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: %s", domain.ErrInvalidCallbackURL, rawURL)
+	}
+	if n.allowPrivate {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: no se pudo resolver %s", domain.ErrInvalidCallbackURL, u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return fmt.Errorf("%w: %s apunta a una dirección no pública (%s)", domain.ErrInvalidCallbackURL, u.Hostname(), addr.Unmap())
+		}
+	}
+	return nil
+}
+
+// checkWebhookDial es el Control del dialer de las notificaciones: rechaza la conexión si la
+// dirección ya resuelta no es pública
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", domain.ErrInvalidCallbackURL, address)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isPublicAddr(addr) {
+		return fmt.Errorf("%w: conexión a %s bloqueada", domain.ErrInvalidCallbackURL, address)
+	}
+	return nil
+}
+
+// nonPublicPrefixes son rangos reservados que netip.Addr no clasifica como privados
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "Esta red"
+	netip.MustParsePrefix("100.64.0.0/10"), // NAT de operador, usado por algunos servicios de metadatos
+	netip.MustParsePrefix("198.18.0.0/15"), // Pruebas de rendimiento
+}
+
+// isPublicAddr indica si addr es una dirección pública: no es de loopback, link-local,
+// privada, no especificada ni multicast
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Notify envía la notificación a url, reintentando hasta agotar los intentos, el timeout
+// del notificador o el contexto
+func (n *WebhookNotifier) Notify(ctx context.Context, url string, notification *domain.JobNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("error codificando notificación: %w", err)
+	}
+
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+
+	delay := n.baseDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := n.send(ctx, url, notification.Event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.maxRetries {
+			return fmt.Errorf("%w: %w", domain.ErrWebhookFailed, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", domain.ErrWebhookFailed, ctx.Err())
+		}
+		delay = min(delay*2, n.maxDelay)
+	}
+}
+
+// send realiza un intento de entrega. retry indica si el error es transitorio.
+func (n *WebhookNotifier) send(ctx context.Context, url, event string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "image-compress-api-webhook")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+n.sign(timestamp, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Una dirección bloqueada no va a dejar de estarlo al reintentar
+		return !errors.Is(err, domain.ErrInvalidCallbackURL), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("respuesta %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("respuesta %d", resp.StatusCode)
+	}
+}
+
+// sign firma "timestamp.body" con HMAC-SHA256. Incluir el timestamp permite al receptor
+// rechazar notificaciones reenviadas.
+func (n *WebhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
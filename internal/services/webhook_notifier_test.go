@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// webhookReceiver es un receptor de notificaciones que responde con statuses en orden
+// (el último se repite) y registra cada petición recibida
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+// receivedWebhook es una notificación recibida por webhookReceiver
+type receivedWebhook struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rcv.mu.Lock()
+	rcv.requests = append(rcv.requests, receivedWebhook{at: time.Now(), header: r.Header.Clone(), body: body})
+	status := rcv.statuses[min(len(rcv.requests), len(rcv.statuses))-1]
+	rcv.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+// received devuelve las notificaciones recibidas hasta el momento
+func (rcv *webhookReceiver) received() []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedWebhook(nil), rcv.requests...)
+}
+
+// newWebhookReceiver arranca un receptor local que responde con statuses
+func newWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, string) {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return receiver, server.URL
+}
+
+// testNotification es la notificación que se envía en las pruebas
+func testNotification() *domain.JobNotification {
+	return &domain.JobNotification{
+		Event: domain.JobEventCompleted,
+		Job:   domain.Job{ID: "job-1", Status: domain.JobCompleted, Total: 1, Processed: 1, Succeeded: 1},
+	}
+}
+
+func TestWebhookNotifierSignsTimestampAndBody(t *testing.T) {
+	receiver, url := newWebhookReceiver(t, http.StatusOK)
+	notifier := NewWebhookNotifier("secreto", 0, time.Millisecond, time.Second, true)
+
+	if err := notifier.Notify(context.Background(), url, testNotification()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("se recibieron %d notificaciones, se esperaba 1", len(requests))
+	}
+	got := requests[0]
+
+	timestamp := got.header.Get("X-Webhook-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(unix, 0)).Abs() > time.Minute {
+		t.Fatalf("X-Webhook-Timestamp = %q, se esperaba la hora actual en segundos", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secreto"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(got.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := got.header.Get("X-Webhook-Signature"); signature != want {
+		t.Fatalf("X-Webhook-Signature = %q, se esperaba %q", signature, want)
+	}
+	if event := got.header.Get("X-Webhook-Event"); event != domain.JobEventCompleted {
+		t.Fatalf("X-Webhook-Event = %q, se esperaba %q", event, domain.JobEventCompleted)
+	}
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	const baseDelay = 20 * time.Millisecond
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "éxito al primer intento", statuses: []int{200}, maxRetries: 3, wantAttempts: 1},
+		{name: "reintenta 5xx y 429 hasta el éxito", statuses: []int{500, 429, 200}, maxRetries: 3, wantAttempts: 3},
+		{name: "se rinde tras agotar los reintentos", statuses: []int{503}, maxRetries: 2, wantAttempts: 3, wantErr: true},
+		{name: "no reintenta otros 4xx", statuses: []int{400}, maxRetries: 3, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, url := newWebhookReceiver(t, tt.statuses...)
+			notifier := NewWebhookNotifier("secreto", tt.maxRetries, baseDelay, 10*time.Second, true)
+
+			err := notifier.Notify(context.Background(), url, testNotification())
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Notify = %v, se esperaba error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, domain.ErrWebhookFailed) {
+				t.Fatalf("Notify = %v, se esperaba ErrWebhookFailed", err)
+			}
+
+			requests := receiver.received()
+			if len(requests) != tt.wantAttempts {
+				t.Fatalf("%d intentos, se esperaban %d", len(requests), tt.wantAttempts)
+			}
+			// La espera entre intentos se duplica: baseDelay, 2*baseDelay...
+			delay := baseDelay
+			for i := 1; i < len(requests); i++ {
+				if gap := requests[i].at.Sub(requests[i-1].at); gap < delay {
+					t.Errorf("espera antes del intento %d = %v, se esperaba al menos %v", i+1, gap, delay)
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierTimeout(t *testing.T) {
+	_, url := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	notifier := NewWebhookNotifier("secreto", 100, 50*time.Millisecond, 200*time.Millisecond, true)
+
+	start := time.Now()
+	err := notifier.Notify(context.Background(), url, testNotification())
+	if !errors.Is(err, domain.ErrWebhookFailed) {
+		t.Fatalf("Notify = %v, se esperaba ErrWebhookFailed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Notify tardó %v, el timeout es de 200ms", elapsed)
+	}
+}
+
+func TestWebhookNotifierBlocksPrivateAddresses(t *testing.T) {
+	receiver, url := newWebhookReceiver(t, http.StatusOK)
+	notifier := NewWebhookNotifier("secreto", 3, time.Millisecond, time.Second, false)
+
+	// La URL se valida al crear el trabajo y la conexión se comprueba otra vez al enviar
+	if err := notifier.ValidateURL(context.Background(), url); !errors.Is(err, domain.ErrInvalidCallbackURL) {
+		t.Fatalf("ValidateURL(%s) = %v, se esperaba ErrInvalidCallbackURL", url, err)
+	}
+	err := notifier.Notify(context.Background(), url, testNotification())
+	if !errors.Is(err, domain.ErrWebhookFailed) || !errors.Is(err, domain.ErrInvalidCallbackURL) {
+		t.Fatalf("Notify = %v, se esperaba una conexión bloqueada", err)
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Fatalf("el receptor local recibió %d notificaciones", n)
+	}
+}
+
+func TestWebhookNotifierValidateURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		secret       string
+		allowPrivate bool
+		wantErr      error
+	}{
+		{url: "http://93.184.215.14/hook", secret: "s"},
+		{url: "https://[2606:4700::1111]/hook", secret: "s"},
+		{url: "http://93.184.215.14/hook", wantErr: domain.ErrWebhookDisabled},
+		{url: "ftp://93.184.215.14/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://127.0.0.1:8080/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://localhost/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://[::1]/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://[::ffff:127.0.0.1]/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://169.254.169.254/latest/meta-data", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://10.0.0.5/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://172.16.3.4/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://192.168.1.10/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://100.100.100.200/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://0.0.0.0/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://[fd00::1]/hook", secret: "s", wantErr: domain.ErrInvalidCallbackURL},
+		{url: "http://127.0.0.1:8080/hook", secret: "s", allowPrivate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			notifier := NewWebhookNotifier(tt.secret, 0, time.Millisecond, time.Second, tt.allowPrivate)
+			err := notifier.ValidateURL(context.Background(), tt.url)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("ValidateURL = %v, se esperaba nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateURL = %v, se esperaba %v", err, tt.wantErr)
+			}
+		})
+	}
+}
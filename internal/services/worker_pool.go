@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// Limiter limita el número de compresiones simultáneas en todo el servidor,
+// de modo que varios lotes concurrentes no saturen las CPUs
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter crea un limitador que permite hasta n tareas simultáneas
+func NewLimiter(n int) *Limiter {
+	if n < 1 {
+		n = 1
+	}
+	return &Limiter{slots: make(chan struct{}, n)}
+}
+
+// Acquire espera un hueco libre o hasta que se cancele el contexto
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release libera un hueco obtenido con Acquire
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+// WorkerPool procesa las imágenes de un lote en paralelo con un número acotado de workers
+type WorkerPool struct {
+	workers int
+	limiter *Limiter
+	metrics domain.MetricsRecorder
+}
+
+// NewWorkerPool crea un pool con workers tareas por lote, compartiendo el limitador global.
+// metrics recibe el tamaño de cada lote; puede ser nil.
+func NewWorkerPool(workers int, limiter *Limiter, metrics domain.MetricsRecorder) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	return &WorkerPool{
+		workers: workers,
+		limiter: limiter,
+		metrics: metrics,
+	}
+}
+
+// Workers devuelve el número de tareas simultáneas por lote
+func (p *WorkerPool) Workers() int {
+	return p.workers
+}
+
+// OrderedBatch ejecuta tareas en paralelo y entrega sus resultados en el orden en que se enviaron.
+// Los resultados se entregan siempre en la goroutine que llama a Submit y Wait, por lo que
+// emit puede escribir en la respuesta HTTP sin sincronización adicional.
+type OrderedBatch[T any] struct {
+	ctx       context.Context
+	cancel    context.CancelFunc // Cancela las tareas pendientes cuando el lote se detiene
+	pool      *WorkerPool
+	emit      func(T, error) error
+	pending   []*batchTask[T]
+	submitted int
+	err       error
+	stopped   bool
+}
+
+// batchTask es una tarea enviada al lote y su resultado
+type batchTask[T any] struct {
+	done   chan struct{}
+	result T
+	err    error
+}
+
+// NewOrderedBatch crea un lote sobre el pool. emit recibe cada resultado en orden, o el error
+// si la tarea no llegó a ejecutarse porque se canceló el contexto. Si emit devuelve un error el
+// lote se detiene, se cancela el contexto de las tareas pendientes y Submit y Wait devuelven
+// ese error.
+func NewOrderedBatch[T any](ctx context.Context, pool *WorkerPool, emit func(T, error) error) *OrderedBatch[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	return &OrderedBatch[T]{
+		ctx:    ctx,
+		cancel: cancel,
+		pool:   pool,
+		emit:   emit,
+	}
+}
+
+// Submit envía una tarea, que recibe el contexto del lote. Si ya hay tantas tareas pendientes
+// como workers, espera a que termine la más antigua y entrega su resultado antes de lanzar la nueva.
+func (b *OrderedBatch[T]) Submit(task func(ctx context.Context) T) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	for len(b.pending) >= b.pool.workers {
+		if err := b.emitOldest(); err != nil {
+			return err
+		}
+	}
+
+	t := &batchTask[T]{done: make(chan struct{})}
+	b.pending = append(b.pending, t)
+	b.submitted++
+	go func() {
+		defer close(t.done)
+		if b.pool.limiter != nil {
+			if err := b.pool.limiter.Acquire(b.ctx); err != nil {
+				t.err = err
+				return
+			}
+			defer b.pool.limiter.Release()
+		}
+		// El lote pudo detenerse mientras la tarea esperaba su turno
+		if err := b.ctx.Err(); err != nil {
+			t.err = err
+			return
+		}
+		t.result = task(b.ctx)
+	}()
+
+	// Entregar sin bloquear los resultados que ya estén listos, para no retrasar el streaming
+	for len(b.pending) > 0 && isDone(b.pending[0].done) {
+		if err := b.emitOldest(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Wait espera a que terminen todas las tareas pendientes y entrega sus resultados
+func (b *OrderedBatch[T]) Wait() error {
+	defer b.Stop()
+	for b.err == nil && len(b.pending) > 0 {
+		b.emitOldest()
+	}
+	return b.err
+}
+
+// emitOldest espera la tarea más antigua y entrega su resultado
+func (b *OrderedBatch[T]) emitOldest() error {
+	t := b.pending[0]
+	<-t.done
+	b.pending = b.pending[1:]
+
+	if err := b.emit(t.result, t.err); err != nil {
+		b.err = err
+		b.Stop()
+	}
+	return b.err
+}
+
+// Stop detiene el lote sin entregar los resultados pendientes: cancela el contexto de las
+// tareas y registra el tamaño del lote una sola vez. Se puede llamar varias veces y después
+// de Wait, así que quien crea el lote lo difiere para cubrir las salidas anticipadas.
+func (b *OrderedBatch[T]) Stop() {
+	if b.stopped {
+		return
+	}
+	b.stopped = true
+	b.cancel()
+	b.pool.metrics.ObserveBatch(b.submitted)
+}
+
+// isDone indica si el canal ya está cerrado sin bloquear
+func isDone(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
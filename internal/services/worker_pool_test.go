@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyTracker registra cuántas tareas se ejecutan a la vez
+type concurrencyTracker struct {
+	current atomic.Int32
+	max     atomic.Int32
+}
+
+// enter marca el inicio de una tarea y devuelve la función que marca su fin
+func (c *concurrencyTracker) enter() func() {
+	n := c.current.Add(1)
+	for {
+		old := c.max.Load()
+		if n <= old || c.max.CompareAndSwap(old, n) {
+			break
+		}
+	}
+	return func() { c.current.Add(-1) }
+}
+
+func TestOrderedBatchPreservesOrder(t *testing.T) {
+	const tasks = 50
+	var tracker concurrencyTracker
+	pool := NewWorkerPool(8, NewLimiter(4), nil)
+
+	var got []int
+	batch := NewOrderedBatch(context.Background(), pool, func(result int, err error) error {
+		if err != nil {
+			t.Fatalf("error inesperado: %v", err)
+		}
+		got = append(got, result)
+		return nil
+	})
+
+	for i := 0; i < tasks; i++ {
+		// Las tareas terminan en desorden: las primeras tardan más
+		delay := time.Duration(rand.Intn(5)+(tasks-i)/10) * time.Millisecond
+		if err := batch.Submit(func(ctx context.Context) int {
+			defer tracker.enter()()
+			time.Sleep(delay)
+			return i
+		}); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	if err := batch.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if len(got) != tasks {
+		t.Fatalf("se entregaron %d resultados, se esperaban %d", len(got), tasks)
+	}
+	for i, result := range got {
+		if result != i {
+			t.Fatalf("resultado %d = %d, el orden no coincide con el de envío: %v", i, result, got)
+		}
+	}
+	if max := tracker.max.Load(); max > 4 {
+		t.Fatalf("%d tareas simultáneas, el limitador permite 4", max)
+	}
+}
+
+func TestLimiterSharedAcrossBatches(t *testing.T) {
+	const limit = 3
+	var tracker concurrencyTracker
+	limiter := NewLimiter(limit)
+
+	var wg sync.WaitGroup
+	for b := 0; b < 4; b++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := NewOrderedBatch(context.Background(), NewWorkerPool(limit, limiter, nil), func(int, error) error { return nil })
+			for i := 0; i < 10; i++ {
+				batch.Submit(func(ctx context.Context) int {
+					defer tracker.enter()()
+					time.Sleep(time.Millisecond)
+					return i
+				})
+			}
+			batch.Wait()
+		}()
+	}
+	wg.Wait()
+
+	if max := tracker.max.Load(); max > limit {
+		t.Fatalf("%d tareas simultáneas entre todos los lotes, el limitador permite %d", max, limit)
+	}
+}
+
+func TestOrderedBatchFailureCancelsPending(t *testing.T) {
+	errFailed := errors.New("la imagen falló")
+	// Un hueco por worker: si las tareas bloqueadas ocuparan el limitador la que falla no correría
+	pool := NewWorkerPool(4, NewLimiter(4), nil)
+
+	var emitted []int
+	batch := NewOrderedBatch(context.Background(), pool, func(result int, err error) error {
+		emitted = append(emitted, result)
+		if result == 0 {
+			return errFailed
+		}
+		return nil
+	})
+
+	var started, finished, timedOut atomic.Int32
+	submitErr := error(nil)
+	for i := 0; i < 10 && submitErr == nil; i++ {
+		submitErr = batch.Submit(func(ctx context.Context) int {
+			started.Add(1)
+			defer finished.Add(1)
+			if i == 0 {
+				time.Sleep(10 * time.Millisecond)
+				return 0
+			}
+			// El resto de tareas espera hasta que el lote se cancele
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				timedOut.Add(1)
+			}
+			return i
+		})
+	}
+
+	if !errors.Is(submitErr, errFailed) {
+		t.Fatalf("Submit = %v, se esperaba %v", submitErr, errFailed)
+	}
+	if err := batch.Wait(); !errors.Is(err, errFailed) {
+		t.Fatalf("Wait = %v, se esperaba %v", err, errFailed)
+	}
+	if len(emitted) != 1 {
+		t.Fatalf("se entregaron %v, tras el fallo no debe entregarse nada más", emitted)
+	}
+
+	// Las tareas en curso deben terminar en cuanto se cancela el lote
+	deadline := time.Now().Add(2 * time.Second)
+	for finished.Load() < started.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d de %d tareas siguen en ejecución tras el fallo", started.Load()-finished.Load(), started.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if timedOut.Load() > 0 {
+		t.Fatalf("%d tareas no recibieron la cancelación", timedOut.Load())
+	}
+	if started.Load() >= 10 {
+		t.Fatalf("se ejecutaron las %d tareas, el fallo debía detener el envío", started.Load())
+	}
+}
+
+func TestOrderedBatchCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter := NewLimiter(1)
+	// Ocupar el único hueco para que la tarea quede esperando al limitador
+	limiter.Acquire(context.Background())
+	defer limiter.Release()
+
+	var gotErr error
+	batch := NewOrderedBatch(ctx, NewWorkerPool(1, limiter, nil), func(result int, err error) error {
+		gotErr = err
+		return err
+	})
+	ran := false
+	batch.Submit(func(ctx context.Context) int {
+		ran = true
+		return 1
+	})
+	cancel()
+
+	if err := batch.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, se esperaba context.Canceled", err)
+	}
+	if !errors.Is(gotErr, context.Canceled) || ran {
+		t.Fatalf("emit recibió %v (tarea ejecutada: %v), se esperaba context.Canceled sin ejecutarla", gotErr, ran)
+	}
+}
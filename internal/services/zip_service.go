@@ -3,76 +3,247 @@ package services
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"fmt"
-	"path/filepath"
+	"io"
+	"net/http"
+	"os"
 	"strings"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
 )
 
 // ZipService implementa la interfaz ZipService
-type ZipService struct{}
+type ZipService struct {
+	method domain.ZipMethod
+}
+
+// NewZipService crea una nueva instancia del servicio ZIP. method indica cómo se comprimen
+// las entradas; con ZipMethodAuto (o vacío) se elige por el contenido de cada una.
+func NewZipService(method domain.ZipMethod) *ZipService {
+	if method == "" {
+		method = domain.ZipMethodAuto
+	}
+	return &ZipService{method: method}
+}
+
+// Format devuelve el formato de archivo que genera el servicio
+func (s *ZipService) Format() domain.ArchiveFormat {
+	return domain.ArchiveZIP
+}
+
+// ContentType devuelve el tipo MIME de los archivos generados
+func (s *ZipService) ContentType() string {
+	return "application/zip"
+}
+
+// CreateArchive crea un archivo ZIP con los archivos proporcionados
+func (s *ZipService) CreateArchive(files []domain.ImageData) ([]byte, error) {
+	return createArchive(s, files)
+}
+
+// NewWriter crea un writer que agrega entradas al ZIP a medida que se reciben
+func (s *ZipService) NewWriter(w io.Writer) domain.ArchiveWriter {
+	return &zipEntryWriter{
+		writer: newZipWriter(w),
+		names:  newEntryNames(),
+		method: s.method,
+	}
+}
+
+// NewStreamWriter crea un writer que envía cada entrada al destino en cuanto se agrega.
+// Si w implementa Flush (por ejemplo http.ResponseWriter) se vacía tras cada entrada,
+// de modo que el ZIP nunca se mantiene completo en memoria.
+func (s *ZipService) NewStreamWriter(w io.Writer) domain.ArchiveWriter {
+	return &zipEntryWriter{
+		writer:  newZipWriter(w),
+		names:   newEntryNames(),
+		method:  s.method,
+		stream:  true,
+		flusher: flusherOf(w),
+	}
+}
+
+// newZipWriter crea un zip.Writer con un nivel de compresión fijo
+func newZipWriter(w io.Writer) *zip.Writer {
+	writer := zip.NewWriter(w)
+	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, compressionLevel)
+	})
+	return writer
+}
+
+// zipEntryWriter implementa domain.ArchiveWriter sobre un zip.Writer
+type zipEntryWriter struct {
+	writer  *zip.Writer
+	names   entryNames
+	method  domain.ZipMethod
+	stream  bool
+	flusher interface{ Flush() }
+}
+
+// AddFile agrega un archivo al ZIP con el nombre sanitizado y sin repetir
+func (z *zipEntryWriter) AddFile(filename string, data []byte) (string, error) {
+	// Sanitizar el nombre del archivo
+	sanitizedFilename := z.names.unique(sanitizeFilename(filename))
+	return sanitizedFilename, z.add(filename, sanitizedFilename, data)
+}
+
+// add crea la entrada sanitizedName con los datos del archivo filename
+func (z *zipEntryWriter) add(filename, sanitizedName string, data []byte) error {
+	// Crear entrada en el ZIP con fecha fija para que la salida sea reproducible
+	writer, err := z.writer.CreateHeader(&zip.FileHeader{
+		Name:     sanitizedName,
+		Method:   z.methodFor(data),
+		Modified: archiveModTime,
+	})
+	if err != nil {
+		return fmt.Errorf("error creando entrada ZIP para %s: %w", filename, err)
+	}
+
+	// Escribir datos del archivo
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("error escribiendo datos para %s: %w", filename, err)
+	}
+
+	// En modo streaming enviar la entrada inmediatamente
+	if z.stream {
+		if err := z.writer.Flush(); err != nil {
+			return fmt.Errorf("error enviando datos para %s: %w", filename, err)
+		}
+		if z.flusher != nil {
+			z.flusher.Flush()
+		}
+	}
 
-// NewZipService crea una nueva instancia del servicio ZIP
-func NewZipService() *ZipService {
-	return &ZipService{}
+	return nil
 }
 
-// CreateZip crea un archivo ZIP con los archivos proporcionados
-func (s *ZipService) CreateZip(files map[string][]byte) ([]byte, error) {
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no hay archivos para comprimir")
+// methodFor elige el método de compresión de una entrada
+func (z *zipEntryWriter) methodFor(data []byte) uint16 {
+	switch z.method {
+	case domain.ZipMethodStore:
+		return zip.Store
+	case domain.ZipMethodDeflate:
+		return zip.Deflate
+	}
+
+	// Los datos ya comprimidos no se reducen con deflate: guardarlos ahorra CPU
+	if storedContentTypes[http.DetectContentType(data)] {
+		return zip.Store
 	}
+	return zip.Deflate
+}
 
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+// storedContentTypes son los tipos de contenido que se guardan sin comprimir en modo auto
+var storedContentTypes = map[string]bool{
+	"image/jpeg":         true,
+	"image/png":          true,
+	"image/webp":         true,
+	"image/gif":          true,
+	"application/zip":    true,
+	"application/x-gzip": true,
+}
 
-	// Agregar cada archivo al ZIP
-	for filename, data := range files {
-		// Sanitizar el nombre del archivo
-		sanitizedFilename := s.sanitizeFilename(filename)
+// AddPath agrega un archivo al ZIP conservando la estructura de carpetas
+func (z *zipEntryWriter) AddPath(path string, data []byte) (string, error) {
+	sanitizedPath, err := sanitizePath(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, path)
+	}
+	sanitizedPath = z.names.unique(sanitizedPath)
+	return sanitizedPath, z.add(path, sanitizedPath, data)
+}
+
+// AddManifest agrega el manifiesto del lote (manifest.json y opcionalmente manifest.csv)
+func (z *zipEntryWriter) AddManifest(manifest *domain.BatchManifest, withCSV bool) error {
+	return writeManifest(z.add, manifest, withCSV)
+}
+
+// Close escribe el directorio central del ZIP
+func (z *zipEntryWriter) Close() error {
+	if err := z.writer.Close(); err != nil {
+		return fmt.Errorf("error cerrando ZIP: %w", err)
+	}
+	return nil
+}
+
+// ReadZip recorre las entradas de un ZIP subido por el cliente aplicando límites contra
+// zip bombs y rechazando rutas inseguras. fn recibe la posición de la entrada en el ZIP,
+// la ruta sanitizada y los datos de cada archivo. Los directorios y enlaces simbólicos no
+// se pasan a fn, así que las carpetas vacías no se conservan.
+func (s *ZipService) ReadZip(data []byte, limits domain.ZipLimits, fn func(index int, path string, data []byte) error) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidArchive, err)
+	}
 
-		// Crear entrada en el ZIP
-		writer, err := zipWriter.Create(sanitizedFilename)
+	if limits.MaxEntries > 0 && len(reader.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: más de %d entradas", domain.ErrArchiveTooLarge, limits.MaxEntries)
+	}
+
+	var total int64
+	for i, file := range reader.File {
+		// Ignorar directorios y enlaces simbólicos
+		mode := file.Mode()
+		if mode.IsDir() || mode&os.ModeSymlink != 0 || strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		path, err := sanitizePath(file.Name)
 		if err != nil {
-			zipWriter.Close()
-			return nil, fmt.Errorf("error creando entrada ZIP para %s: %w", filename, err)
+			return fmt.Errorf("%w: %s", err, file.Name)
 		}
 
-		// Escribir datos del archivo
-		if _, err := writer.Write(data); err != nil {
-			zipWriter.Close()
-			return nil, fmt.Errorf("error escribiendo datos para %s: %w", filename, err)
+		// Rechazar entradas cuyo tamaño declarado ya supera los límites
+		if limits.MaxEntrySize > 0 && file.UncompressedSize64 > uint64(limits.MaxEntrySize) {
+			return fmt.Errorf("%w: %s", domain.ErrArchiveTooLarge, file.Name)
+		}
+		if limits.MaxRatio > 0 && file.CompressedSize64 > 0 && file.UncompressedSize64 > minRatioCheckSize &&
+			file.UncompressedSize64/file.CompressedSize64 > uint64(limits.MaxRatio) {
+			return fmt.Errorf("%w: relación de compresión sospechosa en %s", domain.ErrArchiveTooLarge, file.Name)
+		}
+
+		// Los tamaños de la cabecera pueden ser falsos, así que se limita también la lectura real
+		content, err := s.readEntry(file, limits.MaxEntrySize)
+		if err != nil {
+			return err
+		}
+		total += int64(len(content))
+		if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+			return fmt.Errorf("%w: más de %d bytes descomprimidos", domain.ErrArchiveTooLarge, limits.MaxTotalSize)
 		}
-	}
 
-	// Cerrar el writer del ZIP
-	if err := zipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("error cerrando ZIP: %w", err)
+		if err := fn(i+1, path, content); err != nil {
+			return err
+		}
 	}
 
-	return buf.Bytes(), nil
+	return nil
 }
 
-// sanitizeFilename limpia el nombre del archivo para evitar problemas de seguridad
-func (s *ZipService) sanitizeFilename(filename string) string {
-	// Obtener solo el nombre del archivo sin la ruta
-	baseName := filepath.Base(filename)
+// minRatioCheckSize evita rechazar por relación de compresión archivos pequeños muy repetitivos
+const minRatioCheckSize = 1 << 20
 
-	// Reemplazar caracteres problemáticos
-	baseName = strings.ReplaceAll(baseName, "..", "")
-	baseName = strings.ReplaceAll(baseName, "/", "_")
-	baseName = strings.ReplaceAll(baseName, "\\", "_")
-	baseName = strings.ReplaceAll(baseName, ":", "_")
-	baseName = strings.ReplaceAll(baseName, "*", "_")
-	baseName = strings.ReplaceAll(baseName, "?", "_")
-	baseName = strings.ReplaceAll(baseName, "\"", "_")
-	baseName = strings.ReplaceAll(baseName, "<", "_")
-	baseName = strings.ReplaceAll(baseName, ">", "_")
-	baseName = strings.ReplaceAll(baseName, "|", "_")
+// readEntry lee una entrada del ZIP sin superar maxSize bytes
+func (s *ZipService) readEntry(file *zip.File, maxSize int64) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", domain.ErrInvalidArchive, file.Name, err)
+	}
+	defer rc.Close()
 
-	// Si el nombre está vacío después de la limpieza, usar un nombre por defecto
-	if baseName == "" || baseName == "." {
-		baseName = "image"
+	var reader io.Reader = rc
+	if maxSize > 0 {
+		reader = io.LimitReader(rc, maxSize+1)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", domain.ErrInvalidArchive, file.Name, err)
+	}
+	if maxSize > 0 && int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("%w: %s", domain.ErrArchiveTooLarge, file.Name)
 	}
 
-	return baseName
+	return content, nil
 }
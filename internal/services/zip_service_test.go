@@ -0,0 +1,432 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miguelmoralesr13/image-compress/internal/domain"
+)
+
+// zipEntry es una entrada de los ZIP de prueba
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+// buildZip crea un ZIP en memoria con las entradas dadas. Los nombres que terminan en "/"
+// se agregan como directorios.
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatalf("creando entrada %s: %v", entry.name, err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatalf("escribiendo entrada %s: %v", entry.name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("cerrando ZIP: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildLyingZip crea un ZIP con una entrada cuya cabecera declara declaredSize bytes
+// descomprimidos aunque contiene data
+func buildLyingZip(t *testing.T, name string, data []byte, declaredSize uint64) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	fw.Close()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	w, err := writer.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: declaredSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadZipLimits(t *testing.T) {
+	small := bytes.Repeat([]byte("a"), 100)
+	zeros := make([]byte, 2<<20) // 2MB de ceros, se comprimen más de 100:1
+
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		limits  domain.ZipLimits
+		wantErr error
+	}{
+		{
+			name:    "dentro de los límites",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"a.txt", small}, zipEntry{"b/c.txt", small}) },
+			limits:  domain.ZipLimits{MaxEntries: 2, MaxEntrySize: 100, MaxTotalSize: 200, MaxRatio: 100},
+		},
+		{
+			name:    "demasiadas entradas",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"a.txt", small}, zipEntry{"b.txt", small}) },
+			limits:  domain.ZipLimits{MaxEntries: 1},
+			wantErr: domain.ErrArchiveTooLarge,
+		},
+		{
+			name:    "entrada demasiado grande",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"a.txt", small}) },
+			limits:  domain.ZipLimits{MaxEntrySize: 99},
+			wantErr: domain.ErrArchiveTooLarge,
+		},
+		{
+			// archive/zip detecta que los datos superan el tamaño declarado
+			name:    "cabecera con tamaño falso",
+			archive: func(t *testing.T) []byte { return buildLyingZip(t, "a.txt", small, 10) },
+			limits:  domain.ZipLimits{MaxEntrySize: 50},
+			wantErr: domain.ErrInvalidArchive,
+		},
+		{
+			name:    "total descomprimido demasiado grande",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"a.txt", small}, zipEntry{"b.txt", small}) },
+			limits:  domain.ZipLimits{MaxTotalSize: 150},
+			wantErr: domain.ErrArchiveTooLarge,
+		},
+		{
+			name:    "relación de compresión sospechosa",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"bomba.bin", zeros}) },
+			limits:  domain.ZipLimits{MaxRatio: 100},
+			wantErr: domain.ErrArchiveTooLarge,
+		},
+		{
+			name:    "relación alta sin límite",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"bomba.bin", zeros}) },
+			limits:  domain.ZipLimits{},
+		},
+		{
+			name:    "ruta con ..",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"../evil.txt", small}) },
+			wantErr: domain.ErrUnsafeArchivePath,
+		},
+		{
+			name:    "ruta absoluta",
+			archive: func(t *testing.T) []byte { return buildZip(t, zipEntry{"/etc/passwd", small}) },
+			wantErr: domain.ErrUnsafeArchivePath,
+		},
+		{
+			name:    "datos que no son un ZIP",
+			archive: func(t *testing.T) []byte { return []byte("no es un zip") },
+			wantErr: domain.ErrInvalidArchive,
+		},
+	}
+
+	service := NewZipService(domain.ZipMethodAuto)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.ReadZip(tt.archive(t), tt.limits, func(int, string, []byte) error { return nil })
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("error inesperado: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, se esperaba %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadZipEntries(t *testing.T) {
+	archive := buildZip(t,
+		zipEntry{"fotos/", nil},
+		zipEntry{"fotos/a.jpg", []byte("a")},
+		zipEntry{"vacia/", nil},
+		zipEntry{`docs\leeme.txt`, []byte("b")},
+	)
+
+	type visited struct {
+		index int
+		path  string
+		data  string
+	}
+	var got []visited
+	err := NewZipService(domain.ZipMethodAuto).ReadZip(archive, domain.ZipLimits{}, func(index int, path string, data []byte) error {
+		got = append(got, visited{index, path, string(data)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error inesperado: %v", err)
+	}
+
+	// Los directorios no se pasan a fn, pero cuentan para la posición en el ZIP
+	want := []visited{{2, "fotos/a.jpg", "a"}, {4, "docs/leeme.txt", "b"}}
+	if len(got) != len(want) {
+		t.Fatalf("entradas = %v, se esperaba %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entrada %d = %v, se esperaba %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadZipStopsOnCallbackError(t *testing.T) {
+	archive := buildZip(t, zipEntry{"a.txt", []byte("a")}, zipEntry{"b.txt", []byte("b")})
+	stop := errors.New("detener")
+
+	calls := 0
+	err := NewZipService(domain.ZipMethodAuto).ReadZip(archive, domain.ZipLimits{}, func(int, string, []byte) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("error = %v tras %d llamadas, se esperaba %v tras 1", err, calls, stop)
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "fotos/a.jpg", want: "fotos/a.jpg"},
+		{name: `fotos\2024\a.jpg`, want: "fotos/2024/a.jpg"},
+		{name: "./fotos//a.jpg", want: "fotos/a.jpg"},
+		{name: "fotos/a<1>.jpg", want: "fotos/a_1_.jpg"},
+		{name: "fotos/a..b.jpg", want: "fotos/ab.jpg"},
+		{name: "../a.jpg", wantErr: true},
+		{name: "fotos/../../a.jpg", wantErr: true},
+		{name: `..\a.jpg`, wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: `C:\Windows\a.jpg`, wantErr: true},
+		{name: "fotos/.../a.jpg", wantErr: true},
+		{name: "", wantErr: true},
+		{name: "./", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizePath(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, domain.ErrUnsafeArchivePath) {
+					t.Fatalf("sanitizePath(%q) = %q, %v; se esperaba ErrUnsafeArchivePath", tt.name, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("sanitizePath(%q) = %q, %v; se esperaba %q", tt.name, got, err, tt.want)
+			}
+			if strings.Contains(got, "..") {
+				t.Fatalf("sanitizePath(%q) = %q contiene ..", tt.name, got)
+			}
+		})
+	}
+}
+
+// buildBatchZip comprime las imágenes como lo hace un lote y las escribe en un ZIP con su
+// manifiesto. Devuelve el ZIP y el estado de caché de cada imagen.
+func buildBatchZip(t *testing.T, processor domain.ImageProcessor, images []domain.ImageData, stream bool) ([]byte, []domain.CacheStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	service := NewZipService("")
+	writer := service.NewWriter(rec)
+	if stream {
+		writer = service.NewStreamWriter(rec)
+	}
+
+	manifest := &domain.BatchManifest{Images: []domain.ManifestEntry{}}
+	var statuses []domain.CacheStatus
+	for _, image := range images {
+		result, err := processor.CompressImage(context.Background(), image.Data, 80, domain.JPEG, "")
+		if err != nil {
+			t.Fatalf("CompressImage(%s): %v", image.Filename, err)
+		}
+		statuses = append(statuses, result.Cache)
+
+		output, err := writer.AddFile(domain.OutputFilename(image.Filename, result.Format), result.Data)
+		if err != nil {
+			t.Fatalf("AddFile(%s): %v", image.Filename, err)
+		}
+		manifest.Images = append(manifest.Images, domain.ManifestEntry{
+			Input:      image.Filename,
+			Output:     output,
+			InputSize:  int64(len(image.Data)),
+			OutputSize: result.Size,
+			Format:     result.Format,
+			Quality:    80,
+			Original:   result.Original,
+			Warnings:   result.Warnings,
+		})
+		manifest.Succeeded++
+	}
+	if err := writer.AddManifest(manifest, true); err != nil {
+		t.Fatalf("AddManifest: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return rec.Body.Bytes(), statuses
+}
+
+func TestZipBatchIsReproducible(t *testing.T) {
+	images := []domain.ImageData{
+		{Filename: "ruido.png", Data: noisyPNG(t)},
+		{Filename: "degradado.png", Data: testPNG(t)},
+		{Filename: "ruido.png", Data: noisyPNG(t)},
+	}
+	newProcessor := func(dir string) domain.ImageProcessor {
+		processor, err := NewCachedImageProcessor(NewImageProcessorService(1<<20, nil), 1<<20, dir, 1<<20, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return processor
+	}
+
+	dir := t.TempDir()
+	processor := newProcessor(dir)
+	first, statuses := buildBatchZip(t, processor, images, false)
+	if want := []domain.CacheStatus{domain.CacheMiss, domain.CacheMiss, domain.CacheHit}; !slices.Equal(statuses, want) {
+		t.Fatalf("estados de caché %v, se esperaba %v", statuses, want)
+	}
+
+	tests := []struct {
+		name         string
+		processor    domain.ImageProcessor
+		stream       bool
+		wantStatuses []domain.CacheStatus
+	}{
+		{name: "aciertos de caché", processor: processor, wantStatuses: []domain.CacheStatus{domain.CacheHit, domain.CacheHit, domain.CacheHit}},
+		{name: "aciertos en disco tras reiniciar", processor: newProcessor(dir), wantStatuses: []domain.CacheStatus{domain.CacheHit, domain.CacheHit, domain.CacheHit}},
+		{name: "caché vacía", processor: newProcessor(""), wantStatuses: []domain.CacheStatus{domain.CacheMiss, domain.CacheMiss, domain.CacheHit}},
+		{name: "streaming", processor: processor, stream: true, wantStatuses: []domain.CacheStatus{domain.CacheHit, domain.CacheHit, domain.CacheHit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			again, statuses := buildBatchZip(t, tt.processor, images, tt.stream)
+			if !slices.Equal(statuses, tt.wantStatuses) {
+				t.Fatalf("estados de caché %v, se esperaba %v", statuses, tt.wantStatuses)
+			}
+			if !bytes.Equal(again, first) {
+				t.Fatalf("el ZIP (%d bytes) no es idéntico al primero (%d bytes)", len(again), len(first))
+			}
+		})
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range reader.File {
+		if !file.Modified.Equal(archiveModTime) {
+			t.Errorf("%s tiene fecha %v, se esperaba %v", file.Name, file.Modified, archiveModTime)
+		}
+	}
+}
+
+func TestZipEntryMethod(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("texto comprimido"))
+	gz.Close()
+
+	entries := []struct {
+		name string
+		data []byte
+		auto uint16 // Método esperado con ZipMethodAuto
+	}{
+		{name: "foto.jpg", data: testJPEG(t), auto: zip.Store},
+		{name: "foto.png", data: testPNG(t), auto: zip.Store},
+		{name: "foto.webp", data: []byte("RIFF\x24\x00\x00\x00WEBPVP8 datos de la imagen"), auto: zip.Store},
+		{name: "foto.gif", data: []byte("GIF89a datos de la imagen"), auto: zip.Store},
+		{name: "album.zip", data: buildZip(t, zipEntry{name: "a.txt", data: []byte("a")}), auto: zip.Store},
+		{name: "notas.txt.gz", data: gzipped.Bytes(), auto: zip.Store},
+		{name: "notas.txt", data: []byte(strings.Repeat("texto que se comprime bien ", 20)), auto: zip.Deflate},
+		{name: "datos.bin", data: []byte{0x00, 0x01, 0x02, 0x03}, auto: zip.Deflate},
+	}
+
+	tests := []struct {
+		name   string
+		method domain.ZipMethod
+		want   func(auto uint16) uint16 // Método esperado para las entradas
+		// Método esperado para manifest.json y manifest.csv
+		wantManifest uint16
+	}{
+		{name: "vacío equivale a auto", method: "", want: func(auto uint16) uint16 { return auto }, wantManifest: zip.Deflate},
+		{name: "auto", method: domain.ZipMethodAuto, want: func(auto uint16) uint16 { return auto }, wantManifest: zip.Deflate},
+		{name: "store", method: domain.ZipMethodStore, want: func(uint16) uint16 { return zip.Store }, wantManifest: zip.Store},
+		{name: "deflate", method: domain.ZipMethodDeflate, want: func(uint16) uint16 { return zip.Deflate }, wantManifest: zip.Deflate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := NewZipService(tt.method).NewWriter(&buf)
+			for _, entry := range entries {
+				if _, err := writer.AddFile(entry.name, entry.data); err != nil {
+					t.Fatalf("AddFile(%s): %v", entry.name, err)
+				}
+			}
+			if err := writer.AddManifest(&domain.BatchManifest{Images: []domain.ManifestEntry{}}, true); err != nil {
+				t.Fatalf("AddManifest: %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reader.File) != len(entries)+2 {
+				t.Fatalf("el ZIP tiene %d entradas, se esperaban %d", len(reader.File), len(entries)+2)
+			}
+			for i, file := range reader.File {
+				want := tt.wantManifest
+				if i < len(entries) {
+					want = tt.want(entries[i].auto)
+				}
+				if file.Method != want {
+					t.Errorf("%s usa el método %d, se esperaba %d", file.Name, file.Method, want)
+				}
+
+				rc, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatalf("leyendo %s: %v", file.Name, err)
+				}
+				if i < len(entries) && !bytes.Equal(data, entries[i].data) {
+					t.Errorf("%s no conserva sus datos", file.Name)
+				}
+			}
+		})
+	}
+}